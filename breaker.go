@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errCircuitOpen = errors.New("circuit breaker open, waiting for the next probe of the docker daemon")
+
+// circuitBreaker stops calls to a persistently failing daemon. After
+// threshold consecutive failures it opens, and only lets a probe through
+// every probeInterval until one succeeds.
+type circuitBreaker struct {
+	threshold     int
+	probeInterval time.Duration
+
+	failures  int
+	nextProbe time.Time
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	return !b.nextProbe.IsZero()
+}
+
+// allow reports whether the daemon may be called at now.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	return !b.isOpen() || !now.Before(b.nextProbe)
+}
+
+// record records the outcome of a call made at now.
+func (b *circuitBreaker) record(now time.Time, failed bool) {
+	if !failed {
+		if b.isOpen() {
+			normalLogger.Log("message", "Docker daemon recovered, closing the circuit breaker")
+		}
+		b.failures = 0
+		b.nextProbe = time.Time{}
+		return
+	}
+	b.failures++
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return
+	}
+	if !b.isOpen() {
+		warnLogger.Log("message", "Opening the circuit breaker after consecutive failures", "failures", b.failures, "probe_interval", b.probeInterval.String())
+	}
+	b.nextProbe = now.Add(b.probeInterval)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+)
+
+// capabilities are the features of the docker daemon that some metrics
+// depend on, so they can be left out on older or differently configured
+// engines instead of failing.
+type capabilities struct {
+	// healthInList tells whether the list endpoint reports health statuses,
+	// from API 1.24.
+	healthInList bool
+	// diskUsage tells whether the disk usage endpoint exists, from API 1.25.
+	diskUsage bool
+	// swarm tells whether the daemon is an active swarm node.
+	swarm bool
+}
+
+func (caps *capabilities) byName() map[string]bool {
+	return map[string]bool{
+		"health_in_list": caps.healthInList,
+		"disk_usage":     caps.diskUsage,
+		"swarm":          caps.swarm,
+	}
+}
+
+// versionCapabilities returns the capabilities available with an API
+// version.
+func versionCapabilities(version string) *capabilities {
+	return &capabilities{
+		healthInList: versions.GreaterThanOrEqualTo(version, "1.24"),
+		diskUsage:    versions.GreaterThanOrEqualTo(version, "1.25"),
+	}
+}
+
+// probeCapabilities asks the daemon which capabilities it supports. The API
+// version is negotiated first, so the capabilities are those of the version
+// the requests use, which is lower than the one of the daemon on older
+// clients or with DOCKER_API_VERSION.
+func probeCapabilities(ctx context.Context, cli *client.Client) (*capabilities, error) {
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cli.NegotiateAPIVersionPing(ping)
+	caps := versionCapabilities(cli.ClientVersion())
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	caps.swarm = info.Swarm.LocalNodeState == swarm.LocalNodeStateActive
+	return caps, nil
+}
+
+// probeCapabilities updates the capabilities of the daemon, keeping the
+// previous ones on failure.
+func (c *dockerHealthCollector) probeCapabilities(ctx context.Context) {
+	caps, err := probeCapabilities(ctx, c.containerClient)
+	if err != nil {
+		warnLogger.Log("message", fmt.Sprintf("Failed to probe the docker daemon capabilities: %v", err))
+		return
+	}
+	if previous := c.capabilities.Swap(caps); previous == nil || *previous != *caps {
+		normalLogger.Log("message", "Probed the docker daemon capabilities", "api_version", c.containerClient.ClientVersion(), "health_in_list", caps.healthInList, "disk_usage", caps.diskUsage, "swarm", caps.swarm)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestVersionCapabilities(t *testing.T) {
+	tests := []struct {
+		version      string
+		healthInList bool
+		diskUsage    bool
+	}{
+		{"1.12", false, false},
+		{"1.23", false, false},
+		{"1.24", true, false},
+		{"1.25", true, true},
+		{"1.41", true, true},
+		{"1.100", true, true},
+	}
+	for _, tt := range tests {
+		caps := versionCapabilities(tt.version)
+		if caps.healthInList != tt.healthInList || caps.diskUsage != tt.diskUsage {
+			t.Errorf("versionCapabilities(%q) = %+v, want health_in_list %v, disk_usage %v", tt.version, *caps, tt.healthInList, tt.diskUsage)
+		}
+	}
+}
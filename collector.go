@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// subCollector is a group of metrics collected independently of the others.
+type subCollector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	// Update sends metrics to ch. It should give up once ctx is done.
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
+}
+
+// namedCollector is a subCollector together with its name and timeout.
+type namedCollector struct {
+	name      string
+	timeout   time.Duration
+	collector subCollector
+}
+
+var (
+	collectorSuccessDesc = prometheus.NewDesc(
+		"docker_exporter_collector_success",
+		"Whether the collector succeeded.",
+		[]string{"collector"}, nil)
+	collectorDurationDesc = prometheus.NewDesc(
+		"docker_exporter_collector_duration_seconds",
+		"Duration of the collector.",
+		[]string{"collector"}, nil)
+)
+
+var errCollectorPanicked = errors.New("collector panicked")
+
+// exporter runs every sub-collector in its own goroutine, so a slow or hung
+// collector can only lose its own metrics.
+type exporter struct {
+	collectors []namedCollector
+}
+
+func newExporter(collectors ...namedCollector) *exporter {
+	return &exporter{collectors: collectors}
+}
+
+func (e *exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collectorSuccessDesc
+	ch <- collectorDurationDesc
+	for _, nc := range e.collectors {
+		nc.collector.Describe(ch)
+	}
+}
+
+// collect runs all collectors concurrently. Once ctx is done, the metrics
+// collected so far are returned.
+func (e *exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, nc := range e.collectors {
+		wg.Add(1)
+		go func(nc namedCollector) {
+			defer wg.Done()
+			e.run(ctx, nc, ch)
+		}(nc)
+	}
+	wg.Wait()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		scrapeDeadlineExceededTotal.Inc()
+	}
+}
+
+// run forwards the metrics of nc to ch until it finishes or times out.
+// Metrics sent before a timeout are kept.
+func (e *exporter) run(ctx context.Context, nc namedCollector, ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(ctx, nc.timeout)
+	defer cancel()
+
+	begin := time.Now()
+	metrics := make(chan prometheus.Metric)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(metrics)
+		defer recoverPanic(nc.name + " collector")
+		errc <- nc.collector.Update(ctx, metrics)
+	}()
+
+	var err error
+forward:
+	for {
+		select {
+		case m, ok := <-metrics:
+			if !ok {
+				select {
+				case err = <-errc:
+				default:
+					err = errCollectorPanicked
+				}
+				break forward
+			}
+			ch <- m
+		case <-ctx.Done():
+			err = ctx.Err()
+			// Let the collector finish in the background.
+			go func() {
+				for range metrics {
+				}
+			}()
+			break forward
+		}
+	}
+	duration := time.Since(begin)
+
+	success := 1.0
+	if err != nil {
+		success = 0
+		errorLogger.Log("message", fmt.Sprintf("Collector %s failed after %v: %v", nc.name, duration, err))
+		collectErrorsTotal.Inc()
+	}
+	ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, success, nc.name)
+	ch <- prometheus.MustNewConstMetric(collectorDurationDesc, prometheus.GaugeValue, duration.Seconds(), nc.name)
+}
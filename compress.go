@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+)
+
+// compressedCache holds the container states of the cache JSON encoded, as
+// in -collector.snapshot-file, and gzipped, for -collector.compress-cache.
+// On hosts with thousands of containers it takes a fraction of the memory of
+// the decoded states, which are only decoded one at a time while a scrape
+// emits them. Their metric labels are kept aside, so they are only built,
+// and their values sanitized, when the containers enter the cache.
+type compressedCache struct {
+	data   []byte
+	n      int
+	labels []labelSet
+}
+
+func compressCache(cache []containerState) (*compressedCache, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	labels := make([]labelSet, len(cache))
+	for i := range cache {
+		if err := enc.Encode(&cache[i]); err != nil {
+			return nil, err
+		}
+		labels[i] = cache[i].metricLabels
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	// The buffer grew by doubling, copy it so the spare capacity is freed.
+	return &compressedCache{bytes.Clone(buf.Bytes()), len(cache), labels}, nil
+}
+
+// each decodes the containers in order and calls fn with each of them, along
+// with their metric labels.
+func (cc *compressedCache) each(fn func(info containerState)) error {
+	if cc.n == 0 {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(cc.data))
+	if err != nil {
+		return fmt.Errorf("decompressing the cache: %w", err)
+	}
+	dec := json.NewDecoder(zr)
+	for i := 0; i < cc.n; i++ {
+		var info containerState
+		if err := dec.Decode(&info); err != nil {
+			return fmt.Errorf("decoding the cache: %w", err)
+		}
+		info.metricLabels = cc.labels[i]
+		fn(info)
+	}
+	return nil
+}
+
+// containers decodes all the containers.
+func (cc *compressedCache) containers() ([]containerState, error) {
+	cache := make([]containerState, 0, cc.n)
+	err := cc.each(func(info containerState) { cache = append(cache, info) })
+	return cache, err
+}
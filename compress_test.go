@@ -0,0 +1,157 @@
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCompressedCache(t *testing.T) {
+	cache := benchmarkCache(10)
+	checked := time.Date(2023, 1, 2, 3, 5, 0, 0, time.UTC)
+	sizeRw := int64(4096)
+	cache[0].HealthCheckedAt = checked
+	cache[0].SizeRw = &sizeRw
+	cache[0].Healthcheck = &healthcheck{Configured: true, Interval: 30 * time.Second}
+	// Known empty sections must not become unknown ones.
+	cache[1].Mounts = []mount{}
+	cache[1].Networks = []attachedNetwork{}
+
+	compressed, err := compressCache(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := compressed.containers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cache) {
+		t.Errorf("containers() = %+v, want %+v", got, cache)
+	}
+
+	empty, err := compressCache(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := empty.containers(); err != nil || len(got) != 0 {
+		t.Errorf("containers() = %v, %v, want no containers", got, err)
+	}
+}
+
+// collectedMetrics returns the metrics of a scrape of c, as sorted text,
+// except container_state_duration_seconds which changes over time.
+func collectedMetrics(t *testing.T, c *dockerHealthCollector) []string {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 1024)
+	if err := c.collectMetrics(ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var metrics []string
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), `"container_state_duration_seconds"`) {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, m.Desc().String()+" "+pb.String())
+	}
+	sort.Strings(metrics)
+	return metrics
+}
+
+func TestCompressedCacheMetrics(t *testing.T) {
+	now := time.Now()
+	decoded := &dockerHealthCollector{removed: map[string]removedContainer{}}
+	decoded.setCache(benchmarkCache(10), now)
+	compressed := &dockerHealthCollector{removed: map[string]removedContainer{}, compress: true}
+	compressed.setCache(benchmarkCache(10), now)
+	compressed.compressDecoded()
+	if compressed.compressed == nil || compressed.containerInfoCache != nil {
+		t.Fatal("setCache did not compress the cache")
+	}
+	want, got := collectedMetrics(t, decoded), collectedMetrics(t, compressed)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compressed cache metrics = %q, want %q", got, want)
+	}
+}
+
+func TestCompressedCacheLabels(t *testing.T) {
+	counter := func() float64 {
+		var pb dto.Metric
+		if err := labelValuesSanitizedTotal.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		return pb.GetCounter().GetValue()
+	}
+	c := &dockerHealthCollector{removed: map[string]removedContainer{}, compress: true}
+	cache := benchmarkCache(3)
+	cache[0].Labels["team"] = "web\x00"
+	c.setCache(cache, time.Now())
+	if c.compressed != nil {
+		t.Fatal("setCache compressed the cache right away")
+	}
+	c.compressDecoded()
+	if c.compressed == nil {
+		t.Fatal("compressDecoded did not compress the cache")
+	}
+	before := counter()
+	for i := 0; i < 3; i++ {
+		collectedMetrics(t, c)
+	}
+	if got := counter(); got != before {
+		t.Errorf("%v label values sanitized by scrapes, want none", got-before)
+	}
+}
+
+// BenchmarkCompressedCache measures a scrape of a cache of 5000 containers,
+// decoded or compressed, and reports the memory the cache retains between
+// scrapes.
+func BenchmarkCompressedCache(b *testing.B) {
+	for _, compress := range []bool{false, true} {
+		name := "decoded"
+		if compress {
+			name = "compressed"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := &dockerHealthCollector{removed: map[string]removedContainer{}, compress: compress}
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			c.setCache(benchmarkCache(5000), time.Now())
+			if compress {
+				c.compressDecoded()
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			ch := make(chan prometheus.Metric, 1024)
+			done := make(chan struct{})
+			go func() {
+				for range ch {
+				}
+				close(done)
+			}()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.collectMetrics(ch); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			// ResetTimer drops the metrics reported before it.
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc), "retained-B")
+			close(ch)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the content of the file given by -config.file.
+type config struct {
+	DerivedMetrics []derivedMetricConfig `yaml:"derived_metrics"`
+	SeverityRules  []severityRule        `yaml:"severity_rules"`
+	// SensitiveEnvPatterns are the glob patterns of the environment variable
+	// names counted by container_env_sensitive_vars. When unset, the
+	// defaultSensitiveEnvPatterns are used.
+	SensitiveEnvPatterns []string `yaml:"sensitive_env_patterns"`
+	// sensitiveEnvSet tells whether the config file sets
+	// sensitive_env_patterns.
+	sensitiveEnvSet bool
+}
+
+// derivedMetricConfig defines a gauge that is 1 for every container matching
+// Expr and 0 otherwise.
+type derivedMetricConfig struct {
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	Expr string `yaml:"expr"`
+}
+
+// loadConfig reads and validates the config file. An empty path yields an
+// empty config.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{SensitiveEnvPatterns: defaultSensitiveEnvPatterns}
+	if path == "" {
+		return cfg, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(content, &keys); err == nil {
+		_, cfg.sensitiveEnvSet = keys["sensitive_env_patterns"]
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// reservedMetricPrefixes are the prefixes of the metrics of the exporter
+// that are not declared with newDescSource, and of the Go and process
+// collectors.
+var reservedMetricPrefixes = []string{"docker_exporter_", "dockerstate_", "go_", "process_", "promhttp_"}
+
+// reservedMetricName reports whether a derived metric would clash with a
+// metric of the exporter, which would fail every scrape.
+func reservedMetricName(name string) bool {
+	// The series of histograms have suffixed names.
+	for _, suffix := range []string{"", "_bucket", "_sum", "_count"} {
+		if builtinMetricNames[strings.TrimSuffix(name, suffix)] {
+			return true
+		}
+	}
+	for _, prefix := range reservedMetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *config) validate() error {
+	seen := map[string]bool{}
+	for i, dm := range cfg.DerivedMetrics {
+		if !model.IsValidMetricName(model.LabelValue(dm.Name)) {
+			return fmt.Errorf("derived_metrics[%d]: invalid metric name %q", i, dm.Name)
+		}
+		if reservedMetricName(dm.Name) {
+			return fmt.Errorf("derived_metrics[%d]: metric name %q is used by the exporter", i, dm.Name)
+		}
+		if seen[dm.Name] {
+			return fmt.Errorf("derived_metrics[%d]: duplicate metric name %q", i, dm.Name)
+		}
+		seen[dm.Name] = true
+		if _, err := compileBoolExpr(dm.Expr, derivedFields); err != nil {
+			return fmt.Errorf("derived_metrics[%d] %s: %w", i, dm.Name, err)
+		}
+	}
+	for i := range cfg.SeverityRules {
+		if err := cfg.SeverityRules[i].validate(); err != nil {
+			return fmt.Errorf("severity_rules[%d]: %w", i, err)
+		}
+	}
+	return validateEnvPatterns(cfg.SensitiveEnvPatterns)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReservedMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"container_state_status", true},
+		{"container_state_oomkilled", true},
+		{"container_uptime_seconds", true},
+		{"container_uptime_seconds_bucket", true},
+		{"container_uptime_seconds_count", true},
+		{"docker_engine_info", true},
+		{"docker_exporter_anything", true},
+		{"go_goroutines", true},
+		{"process_cpu_seconds_total", true},
+		{"container_unhealthy_restarting", false},
+		{"container_state_status_total", false},
+		{"my_go_metric", false},
+	}
+	for _, tt := range tests {
+		if got := reservedMetricName(tt.name); got != tt.want {
+			t.Errorf("reservedMetricName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConfigValidateReservedName(t *testing.T) {
+	cfg := &config{DerivedMetrics: []derivedMetricConfig{
+		{Name: "container_unhealthy_restarting", Expr: "unhealthy && restartcount > 0"},
+		{Name: "container_state_oomkilled", Expr: "oomkilled"},
+	}}
+	err := cfg.validate()
+	if err == nil {
+		t.Fatal("validate() succeeded, want an error")
+	}
+	if want := `derived_metrics[1]: metric name "container_state_oomkilled" is used by the exporter`; err.Error() != want {
+		t.Errorf("validate() = %q, want %q", err, want)
+	}
+}
+
+func TestLoadConfigSensitiveEnvSet(t *testing.T) {
+	tests := []struct {
+		content string
+		set     bool
+		want    []string
+	}{
+		{"derived_metrics: []\n", false, defaultSensitiveEnvPatterns},
+		{"sensitive_env_patterns:\n  - \"*_TOKEN\"\n", true, []string{"*_TOKEN"}},
+		{"sensitive_env_patterns: []\n", true, []string{}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.sensitiveEnvSet != tt.set || !reflect.DeepEqual(cfg.SensitiveEnvPatterns, tt.want) {
+			t.Errorf("%q: set = %v, patterns = %q, want %v, %q", tt.content, cfg.sensitiveEnvSet, cfg.SensitiveEnvPatterns, tt.set, tt.want)
+		}
+	}
+}
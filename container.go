@@ -0,0 +1,245 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// containerState is the part of a container the collector uses. It is
+// converted from the inspect response at collection time, so only the fields
+// exported as metrics are retained for every container: a summary of the host
+// configuration, mounts and networks, but not the raw sections, the
+// environment values or the full healthcheck log. It is saved as is to
+// -collector.snapshot-file.
+type containerState struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Image        string            `json:"image"`
+	ImageID      string            `json:"image_id"`
+	Created      string            `json:"created"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	RestartCount int               `json:"restart_count"`
+
+	Status     string `json:"status"`
+	Pid        int    `json:"pid"`
+	OOMKilled  bool   `json:"oom_killed"`
+	ExitCode   int    `json:"exit_code"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at"`
+	// Error is the error of the last start attempt, such as a missing mount.
+	Error string `json:"error,omitempty"`
+
+	// Health is "none" for containers without healthcheck.
+	Health        string `json:"health"`
+	FailingStreak int    `json:"failing_streak"`
+	HealthOutput  string `json:"health_output,omitempty"`
+	// HealthExitCode and HealthCheckDuration are those of the last
+	// healthcheck, which ended at HealthCheckedAt. HealthCheckedAt is zero if
+	// no healthcheck ran.
+	HealthExitCode      int           `json:"health_exit_code"`
+	HealthCheckDuration time.Duration `json:"health_check_duration"`
+	HealthCheckedAt     time.Time     `json:"health_checked_at"`
+	// FirstHealthyAt is the end of the first successful healthcheck since
+	// the container started, if still in the healthcheck log.
+	FirstHealthyAt time.Time `json:"first_healthy_at"`
+
+	// RestartPolicy is empty if the host configuration is unknown.
+	RestartPolicy     string `json:"restart_policy,omitempty"`
+	RestartMaxRetries int    `json:"restart_max_retries,omitempty"`
+
+	// Host is nil if the host configuration is unknown.
+	Host *hostSettings `json:"host,omitempty"`
+
+	// Processes is the number of processes with -collector.processes, 0 if
+	// unknown.
+	Processes int `json:"processes,omitempty"`
+
+	// SizeRw and SizeRootFs are the sizes of the writable layer and of the
+	// whole filesystem with -collector.size, nil if unknown.
+	SizeRw     *int64 `json:"size_rw,omitempty"`
+	SizeRootFs *int64 `json:"size_rootfs,omitempty"`
+
+	// LogFile is the path on the host of the log file, empty if the log
+	// driver does not write to one.
+	LogFile string `json:"log_file,omitempty"`
+
+	// Mounts is nil if the mounts are unknown.
+	Mounts []mount `json:"mounts"`
+
+	// Ports are the ports published on the host.
+	Ports []publishedPort `json:"ports,omitempty"`
+
+	// Networks are the attached networks, nil if unknown.
+	Networks []attachedNetwork `json:"networks"`
+
+	// ImageDetails are empty if the image was not inspected.
+	ImageDetails imageDetails `json:"image_details"`
+
+	// Healthcheck is nil if the configuration is unknown.
+	Healthcheck *healthcheck `json:"healthcheck,omitempty"`
+
+	// Entrypoint and Cmd are the command of the container.
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	Cmd        []string `json:"cmd,omitempty"`
+
+	// User is the user the container runs as, including the one of its
+	// image, nil if unknown.
+	User *string `json:"user,omitempty"`
+
+	// EnvNames are the names of the environment variables, their values are
+	// never kept.
+	EnvNames []string `json:"env_names,omitempty"`
+
+	// metricLabels are the labels of the metrics of the container, built once
+	// when it enters the cache, see setCache.
+	metricLabels labelSet
+}
+
+// newContainerState converts an inspect response. Parts left out by the
+// daemon, or by -inspect.fields, get their zero value.
+func newContainerState(info *types.ContainerJSON) containerState {
+	var s containerState
+	if base := info.ContainerJSONBase; base != nil {
+		s.ID = base.ID
+		s.ImageID = base.Image
+		s.Name = strings.TrimPrefix(base.Name, "/")
+		s.Created = base.Created
+		s.RestartCount = base.RestartCount
+		s.SizeRw = base.SizeRw
+		s.SizeRootFs = base.SizeRootFs
+		s.LogFile = logFile(base)
+		if state := base.State; state != nil {
+			s.Status = state.Status
+			s.Pid = state.Pid
+			s.OOMKilled = state.OOMKilled
+			s.ExitCode = state.ExitCode
+			s.StartedAt = state.StartedAt
+			s.FinishedAt = state.FinishedAt
+			s.Error = state.Error
+			if health := state.Health; health != nil {
+				s.Health = health.Status
+				s.FailingStreak = health.FailingStreak
+				// Malformed responses may hold null results, which are
+				// skipped.
+				for i := len(health.Log) - 1; i >= 0; i-- {
+					if last := health.Log[i]; last != nil {
+						s.HealthOutput = strings.TrimSpace(last.Output)
+						s.HealthExitCode = last.ExitCode
+						s.HealthCheckDuration = last.End.Sub(last.Start)
+						s.HealthCheckedAt = last.End
+						break
+					}
+				}
+				if started, err := time.Parse(time.RFC3339Nano, state.StartedAt); err == nil {
+					for _, result := range health.Log {
+						if result != nil && result.ExitCode == 0 && !result.Start.Before(started) {
+							s.FirstHealthyAt = result.End
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+	if base := info.ContainerJSONBase; base != nil && base.HostConfig != nil {
+		s.RestartPolicy = base.HostConfig.RestartPolicy.Name
+		if s.RestartPolicy == "" {
+			s.RestartPolicy = "no"
+		}
+		s.RestartMaxRetries = base.HostConfig.RestartPolicy.MaximumRetryCount
+		s.Host = newHostSettings(base.HostConfig)
+	}
+	if info.Mounts != nil {
+		s.Mounts = newMounts(info.Mounts)
+	}
+	if info.NetworkSettings != nil {
+		s.Ports = newPublishedPorts(info.NetworkSettings)
+		s.Networks = newAttachedNetworks(info.NetworkSettings.Networks)
+	}
+	if s.Health == "" {
+		s.Health = "none"
+	}
+	if config := info.Config; config != nil {
+		s.Image = config.Image
+		s.Labels = config.Labels
+		s.Healthcheck = &healthcheck{}
+		if hc := config.Healthcheck; hc != nil && len(hc.Test) > 0 && hc.Test[0] != "NONE" {
+			s.Healthcheck = &healthcheck{
+				Configured:  true,
+				Interval:    defaultDuration(hc.Interval, defaultHealthcheckInterval),
+				Timeout:     defaultDuration(hc.Timeout, defaultHealthcheckTimeout),
+				Retries:     hc.Retries,
+				StartPeriod: hc.StartPeriod,
+			}
+			if s.Healthcheck.Retries == 0 {
+				s.Healthcheck.Retries = defaultHealthcheckRetries
+			}
+		}
+		user := config.User
+		s.User = &user
+		s.Entrypoint = config.Entrypoint
+		s.Cmd = config.Cmd
+		for _, kv := range config.Env {
+			name, _, _ := strings.Cut(kv, "=")
+			s.EnvNames = append(s.EnvNames, name)
+		}
+	}
+	return s
+}
+
+// runsAsRoot reports whether a user runs as root. An empty user is the
+// default, root. User namespace remapping is not considered.
+func runsAsRoot(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+// mountTypes are the types of mounts counted by container_mounts.
+var mountTypes = []string{"bind", "volume", "tmpfs", "npipe"}
+
+// mount is a volume, bind mount or tmpfs of a container.
+type mount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	RW          bool   `json:"rw"`
+}
+
+func newMounts(points []types.MountPoint) []mount {
+	mounts := make([]mount, 0, len(points))
+	for _, point := range points {
+		mounts = append(mounts, mount{
+			Type:        string(point.Type),
+			Source:      point.Source,
+			Destination: point.Destination,
+			RW:          point.RW,
+		})
+	}
+	return mounts
+}
+
+// Docker uses these for the healthcheck settings left unset.
+const (
+	defaultHealthcheckInterval = 30 * time.Second
+	defaultHealthcheckTimeout  = 30 * time.Second
+	defaultHealthcheckRetries  = 3
+)
+
+// healthcheck is the healthcheck configuration of a container, including
+// the one inherited from its image, with the defaults of docker applied.
+type healthcheck struct {
+	Configured  bool          `json:"configured"`
+	Interval    time.Duration `json:"interval,omitempty"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+	Retries     int           `json:"retries,omitempty"`
+	StartPeriod time.Duration `json:"start_period,omitempty"`
+}
+
+func defaultDuration(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
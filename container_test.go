@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	tcontainer "github.com/docker/docker/api/types/container"
+)
+
+func TestNewContainerState(t *testing.T) {
+	started := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	healthLog := []*types.HealthcheckResult{
+		// Ran before the container last started.
+		{Start: started.Add(-time.Minute), End: started.Add(-time.Minute + time.Second), ExitCode: 0},
+		{Start: started.Add(10 * time.Second), End: started.Add(11 * time.Second), ExitCode: 1, Output: "connection refused"},
+		{Start: started.Add(40 * time.Second), End: started.Add(42 * time.Second), ExitCode: 0, Output: "ok\n"},
+	}
+	root := ""
+
+	tests := []struct {
+		name string
+		info types.ContainerJSON
+		want containerState
+	}{
+		{
+			name: "nothing",
+			info: types.ContainerJSON{},
+			want: containerState{Health: "none"},
+		},
+		{
+			name: "nil State and Config",
+			info: types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+				ID:           "aaa111",
+				Name:         "/web",
+				Image:        "sha256:0123",
+				Created:      "2023-01-02T03:04:00Z",
+				RestartCount: 2,
+			}},
+			want: containerState{ID: "aaa111", Name: "web", ImageID: "sha256:0123", Created: "2023-01-02T03:04:00Z", RestartCount: 2, Health: "none"},
+		},
+		{
+			name: "nil Health",
+			info: types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{
+				Status:     "exited",
+				ExitCode:   137,
+				OOMKilled:  true,
+				StartedAt:  "2023-01-02T03:04:05Z",
+				FinishedAt: "2023-01-02T04:00:00Z",
+			}}},
+			want: containerState{Status: "exited", ExitCode: 137, OOMKilled: true, StartedAt: "2023-01-02T03:04:05Z", FinishedAt: "2023-01-02T04:00:00Z", Health: "none"},
+		},
+		{
+			name: "never started",
+			info: types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{
+				Status:     "created",
+				StartedAt:  "0001-01-01T00:00:00Z",
+				FinishedAt: "0001-01-01T00:00:00Z",
+				Health:     &types.Health{Status: types.Starting},
+			}}},
+			want: containerState{Status: "created", StartedAt: "0001-01-01T00:00:00Z", FinishedAt: "0001-01-01T00:00:00Z", Health: types.Starting},
+		},
+		{
+			name: "health log",
+			info: types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{
+				Status:    "running",
+				Pid:       1234,
+				StartedAt: started.Format(time.RFC3339Nano),
+				Health:    &types.Health{Status: types.Healthy, FailingStreak: 0, Log: healthLog},
+			}}},
+			want: containerState{
+				Status:              "running",
+				Pid:                 1234,
+				StartedAt:           started.Format(time.RFC3339Nano),
+				Health:              types.Healthy,
+				HealthOutput:        "ok",
+				HealthCheckDuration: 2 * time.Second,
+				HealthCheckedAt:     started.Add(42 * time.Second),
+				FirstHealthyAt:      started.Add(42 * time.Second),
+			},
+		},
+		{
+			name: "health log with an empty start time",
+			info: types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{
+				Status: "running",
+				Health: &types.Health{Status: types.Unhealthy, FailingStreak: 3, Log: healthLog[1:2]},
+			}}},
+			want: containerState{
+				Status:              "running",
+				Health:              types.Unhealthy,
+				FailingStreak:       3,
+				HealthOutput:        "connection refused",
+				HealthExitCode:      1,
+				HealthCheckDuration: time.Second,
+				HealthCheckedAt:     started.Add(11 * time.Second),
+			},
+		},
+		{
+			name: "null health log entries",
+			info: types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{
+				Status:    "running",
+				StartedAt: started.Format(time.RFC3339Nano),
+				Health:    &types.Health{Status: types.Healthy, Log: []*types.HealthcheckResult{nil, healthLog[2], nil}},
+			}}},
+			want: containerState{
+				Status:              "running",
+				StartedAt:           started.Format(time.RFC3339Nano),
+				Health:              types.Healthy,
+				HealthOutput:        "ok",
+				HealthCheckDuration: 2 * time.Second,
+				HealthCheckedAt:     started.Add(42 * time.Second),
+				FirstHealthyAt:      started.Add(42 * time.Second),
+			},
+		},
+		{
+			name: "config without healthcheck",
+			info: types.ContainerJSON{Config: &tcontainer.Config{
+				Image:  "nginx:latest",
+				Labels: map[string]string{"team": "web"},
+			}},
+			want: containerState{
+				Image:       "nginx:latest",
+				Labels:      map[string]string{"team": "web"},
+				Health:      "none",
+				Healthcheck: &healthcheck{},
+				User:        &root,
+			},
+		},
+		{
+			name: "environment",
+			info: types.ContainerJSON{Config: &tcontainer.Config{Env: []string{"PATH=/usr/bin", "API_TOKEN=secret=with=equals", "EMPTY"}}},
+			want: containerState{Health: "none", Healthcheck: &healthcheck{}, User: &root, EnvNames: []string{"PATH", "API_TOKEN", "EMPTY"}},
+		},
+		{
+			name: "healthcheck disabled",
+			info: types.ContainerJSON{Config: &tcontainer.Config{Healthcheck: &tcontainer.HealthConfig{Test: []string{"NONE"}}}},
+			want: containerState{Health: "none", Healthcheck: &healthcheck{}, User: &root},
+		},
+		{
+			name: "healthcheck defaults",
+			info: types.ContainerJSON{Config: &tcontainer.Config{Healthcheck: &tcontainer.HealthConfig{Test: []string{"CMD", "true"}, StartPeriod: time.Minute}}},
+			want: containerState{
+				Health: "none",
+				Healthcheck: &healthcheck{
+					Configured:  true,
+					Interval:    defaultHealthcheckInterval,
+					Timeout:     defaultHealthcheckTimeout,
+					Retries:     defaultHealthcheckRetries,
+					StartPeriod: time.Minute,
+				},
+				User: &root,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newContainerState(&tt.info); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newContainerState() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewContainerStateRestartPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy tcontainer.RestartPolicy
+		want   string
+	}{
+		{tcontainer.RestartPolicy{}, "no"},
+		{tcontainer.RestartPolicy{Name: "always"}, "always"},
+		{tcontainer.RestartPolicy{Name: "on-failure", MaximumRetryCount: 5}, "on-failure"},
+	} {
+		info := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{HostConfig: &tcontainer.HostConfig{RestartPolicy: tt.policy}}}
+		s := newContainerState(&info)
+		if s.RestartPolicy != tt.want || s.RestartMaxRetries != tt.policy.MaximumRetryCount {
+			t.Errorf("policy %+v: got %q with %d retries, want %q with %d", tt.policy, s.RestartPolicy, s.RestartMaxRetries, tt.want, tt.policy.MaximumRetryCount)
+		}
+		if s.Host == nil {
+			t.Errorf("policy %+v: Host is nil", tt.policy)
+		}
+	}
+}
+
+func TestNewContainerStateNullHealthLog(t *testing.T) {
+	var info types.ContainerJSON
+	if err := json.Unmarshal([]byte(`{"State":{"Status":"running","Health":{"Status":"healthy","Log":[null]}}}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	s := newContainerState(&info)
+	if s.Health != types.Healthy || !s.HealthCheckedAt.IsZero() {
+		t.Errorf("newContainerState() = %+v, want healthy without a last check", s)
+	}
+}
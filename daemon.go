@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// userHZ is the unit of process start times in /proc, which is 100 on all
+// common Linux platforms.
+const userHZ = 100
+
+var (
+	daemonStartDesc = newDescSource(
+		"docker_daemon_start_timestamp_seconds",
+		"Time when the docker daemon started.")
+	hostBootDesc = newDescSource(
+		"docker_host_boot_timestamp_seconds",
+		"Time when the docker host booted.")
+)
+
+// daemonCollector exports information about the docker daemon and its host.
+// The daemon start time is read from procfs, which requires the exporter to
+// share the PID namespace of the host. Otherwise, it is the time the daemon
+// answered a ping again after failing to, once it restarted.
+type daemonCollector struct {
+	procfs string
+	cli    *client.Client
+	state  *dockerHealthCollector
+
+	mu  sync.Mutex
+	pid int
+	// down tells whether the last ping failed, restartedAt when one
+	// succeeded again afterwards.
+	down        bool
+	restartedAt time.Time
+}
+
+func (c *daemonCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- daemonStartDesc.Desc(nil)
+	ch <- hostBootDesc.Desc(nil)
+}
+
+func (c *daemonCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	boot, err := readBootTime(c.procfs)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Not a Linux host.
+	case err != nil:
+		return err
+	default:
+		ch <- prometheus.MustNewConstMetric(hostBootDesc.Desc(nil), prometheus.GaugeValue, float64(boot))
+		ticks, found, err := c.daemonStartTicks()
+		if err != nil {
+			return err
+		}
+		if found {
+			ch <- prometheus.MustNewConstMetric(daemonStartDesc.Desc(nil), prometheus.GaugeValue, float64(boot)+float64(ticks)/userHZ)
+			return nil
+		}
+	}
+	if restartedAt := c.pingRestart(ctx); !restartedAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(daemonStartDesc.Desc(nil), prometheus.GaugeValue, float64(restartedAt.UnixNano())/1e9)
+	}
+	return nil
+}
+
+// daemonStartTicks returns the start time of the daemon process in clock
+// ticks after boot, if procfs shows it.
+func (c *daemonCollector) daemonStartTicks() (ticks int64, found bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pid == 0 || readComm(c.procfs, c.pid) != "dockerd" {
+		c.pid = findProcess(c.procfs, "dockerd")
+	}
+	if c.pid == 0 {
+		return 0, false, nil
+	}
+	ticks, err = readStartTicks(c.procfs, c.pid)
+	return ticks, err == nil, err
+}
+
+// pingRestart pings the daemon and returns when it last answered again after
+// failing to, zero if it was not seen restarting.
+func (c *daemonCollector) pingRestart(ctx context.Context) time.Time {
+	if c.cli == nil {
+		return time.Time{}
+	}
+	// The daemon is not called while the circuit breaker is open, it is down.
+	err := errCircuitOpen
+	if !c.state.circuitOpen() {
+		_, err = c.cli.Ping(ctx)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case err != nil && ctx.Err() == nil:
+		c.down = true
+	case err == nil && c.down:
+		c.down = false
+		c.restartedAt = time.Now()
+	}
+	return c.restartedAt
+}
+
+// readBootTime returns the boot time of the host in Unix seconds.
+func readBootTime(procfs string) (int64, error) {
+	f, err := os.Open(filepath.Join(procfs, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "btime" {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("btime not found in %s", f.Name())
+}
+
+func readComm(procfs string, pid int) string {
+	comm, err := os.ReadFile(filepath.Join(procfs, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+// findProcess returns the PID of the first process named comm, or 0.
+func findProcess(procfs, comm string) int {
+	entries, err := os.ReadDir(procfs)
+	if err != nil {
+		return 0
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if readComm(procfs, pid) == comm {
+			return pid
+		}
+	}
+	return 0
+}
+
+// pidMissing reports whether a process is gone from procfs. It is not known
+// when procfs does not show the processes of the host either, such as
+// outside of the PID namespace of the host, which daemonVisible tells from
+// the process of the daemon.
+func pidMissing(procfs string, pid int, daemonVisible bool) (missing, known bool) {
+	if _, err := os.Stat(filepath.Join(procfs, strconv.Itoa(pid))); !errors.Is(err, os.ErrNotExist) {
+		return false, err == nil
+	}
+	return daemonVisible, daemonVisible
+}
+
+// readStartTicks returns the start time of a process in clock ticks after
+// boot.
+func readStartTicks(procfs string, pid int) (int64, error) {
+	stat, err := os.ReadFile(filepath.Join(procfs, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces, the fields start after it.
+	s := string(stat)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	// starttime is field 22, fields starts at field 3.
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	return strconv.ParseInt(fields[19], 10, 64)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+func TestPidMissing(t *testing.T) {
+	procfs := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(procfs, "42"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		pid           int
+		daemonVisible bool
+		missing       bool
+		known         bool
+	}{
+		{42, true, false, true},
+		{42, false, false, true},
+		{43, true, true, true},
+		{43, false, false, false},
+	} {
+		missing, known := pidMissing(procfs, tt.pid, tt.daemonVisible)
+		if missing != tt.missing || known != tt.known {
+			t.Errorf("pidMissing(%d, %v) = %v, %v, want %v, %v", tt.pid, tt.daemonVisible, missing, known, tt.missing, tt.known)
+		}
+	}
+}
+
+func TestPingRestart(t *testing.T) {
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("API-Version", "1.41")
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &daemonCollector{procfs: t.TempDir(), cli: cli, state: &dockerHealthCollector{}}
+	ctx := context.Background()
+
+	if got := c.pingRestart(ctx); !got.IsZero() {
+		t.Errorf("pingRestart() = %v before any restart, want zero", got)
+	}
+	down.Store(true)
+	if got := c.pingRestart(ctx); !got.IsZero() {
+		t.Errorf("pingRestart() = %v while down, want zero", got)
+	}
+	down.Store(false)
+	restarted := c.pingRestart(ctx)
+	if restarted.IsZero() {
+		t.Fatal("pingRestart() is zero after a restart")
+	}
+	if got := c.pingRestart(ctx); !got.Equal(restarted) {
+		t.Errorf("pingRestart() = %v, want the restart at %v", got, restarted)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// collectError is the last error of a cache refresh.
+type collectError struct {
+	err  string
+	time time.Time
+}
+
+// goroutineStacks returns the stacks of all goroutines.
+func goroutineStacks() string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// writeDiagnostics writes the internal state of the exporter, to diagnose
+// hangs in the docker client without restarting it. It never waits for the
+// collector, which may be the one hanging.
+func writeDiagnostics(w io.Writer, c *dockerHealthCollector) {
+	fmt.Fprintf(w, "cache size: %d\n", c.cacheSize.Load())
+	if last := c.lastError.Load(); last != nil {
+		fmt.Fprintf(w, "last error: %s (%s ago)\n", last.err, time.Since(last.time).Round(time.Second))
+	} else {
+		fmt.Fprintln(w, "last error: none")
+	}
+	fmt.Fprintf(w, "goroutines: %d\n\n%s", runtime.NumGoroutine(), goroutineStacks())
+}
+
+// debugHandler serves the diagnostics on /-/debug.
+func debugHandler(c *dockerHealthCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeDiagnostics(w, c)
+	})
+}
+
+// dumpDiagnosticsOnSignal logs the diagnostics on every SIGQUIT instead of
+// terminating the process.
+func dumpDiagnosticsOnSignal(c *dockerHealthCollector) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGQUIT)
+	for range ch {
+		var b strings.Builder
+		writeDiagnostics(&b, c)
+		normalLogger.Log("message", "Diagnostics dump", "diagnostics", b.String())
+	}
+}
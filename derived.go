@@ -0,0 +1,88 @@
+package main
+
+// derivedFields lists the container fields usable in derived metric
+// expressions.
+var derivedFields = map[string]exprType{
+	"paused":       boolType,
+	"restarting":   boolType,
+	"running":      boolType,
+	"removing":     boolType,
+	"dead":         boolType,
+	"created":      boolType,
+	"exited":       boolType,
+	"starting":     boolType,
+	"healthy":      boolType,
+	"unhealthy":    boolType,
+	"oomkilled":    boolType,
+	"restartcount": numberType,
+	"exitcode":     numberType,
+	"status":       stringType,
+	"health":       stringType,
+	"name":         stringType,
+	"image":        stringType,
+}
+
+// fastUnknownFields lists the derived metric fields -collector.fast does not
+// know, which expressions cannot use in that mode.
+var fastUnknownFields = []string{"oomkilled", "restartcount"}
+
+// containerEnv exposes a container to derived metric expressions.
+type containerEnv struct {
+	info *containerState
+}
+
+func (e containerEnv) lookup(name string) exprValue {
+	state := e.info
+	switch name {
+	case "paused", "restarting", "running", "removing", "dead", "created", "exited":
+		return exprValue{b: state.Status == name}
+	case "starting", "healthy", "unhealthy":
+		return exprValue{b: state.Health == name}
+	case "oomkilled":
+		return exprValue{b: state.OOMKilled}
+	case "restartcount":
+		return exprValue{n: float64(state.RestartCount)}
+	case "exitcode":
+		return exprValue{n: float64(state.ExitCode)}
+	case "status":
+		return exprValue{s: state.Status}
+	case "health":
+		return exprValue{s: state.Health}
+	case "name":
+		return exprValue{s: state.Name}
+	case "image":
+		return exprValue{s: state.Image}
+	}
+	return exprValue{}
+}
+
+type derivedMetric struct {
+	desc descSource
+	expr *boolExpr
+}
+
+func newDerivedMetrics(cfgs []derivedMetricConfig, fast bool) ([]derivedMetric, error) {
+	fields := derivedFields
+	if fast {
+		fields = make(map[string]exprType, len(derivedFields))
+		for name, typ := range derivedFields {
+			fields[name] = typ
+		}
+		for _, name := range fastUnknownFields {
+			delete(fields, name)
+		}
+	}
+	var metrics []derivedMetric
+	for _, cfg := range cfgs {
+		expr, err := compileBoolExpr(cfg.Expr, fields)
+		if err != nil {
+			return nil, err
+		}
+		help := cfg.Help
+		if help == "" {
+			help = "Derived metric: " + cfg.Expr
+		}
+		metrics = append(metrics, derivedMetric{descSource{cfg.Name, help}, expr})
+	}
+	return metrics, nil
+}
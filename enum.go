@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// With -metrics.state-encoding=enum, the status and health status of a
+// container are each exported as a single series whose value is the index of
+// the status in containerStatuses or healthStatuses, instead of one series
+// per possible status. New statuses must therefore only be appended to those
+// lists.
+var (
+	statusCodeDesc = newDescSource(
+		namespace+"status_code",
+		"Status of the Container, as mapped by container_state_status_code_info. -1 for an unknown status.")
+	healthStatusCodeDesc = newDescSource(
+		namespace+"health_status_code",
+		"Health status of the Container, as mapped by container_state_health_status_code_info. -1 for an unknown status.")
+	statusCodeInfoDesc = newDescSource(
+		namespace+"status_code_info",
+		"Mapping of the values of container_state_status_code to statuses. The value is always 1.")
+	healthStatusCodeInfoDesc = newDescSource(
+		namespace+"health_status_code_info",
+		"Mapping of the values of container_state_health_status_code to health statuses. The value is always 1.")
+)
+
+// stateEncodings are the values of -metrics.state-encoding.
+var stateEncodings = []string{"labels", "enum"}
+
+// statusCode returns the index of status in statuses, or -1.
+func statusCode(statuses []string, status string) float64 {
+	for i, s := range statuses {
+		if s == status {
+			return float64(i)
+		}
+	}
+	return -1
+}
+
+// collectStatusCodes sends the mappings of the enum encoding.
+func collectStatusCodes(ch chan<- prometheus.Metric) {
+	for _, m := range []struct {
+		desc     *descSource
+		statuses []string
+	}{
+		{&statusCodeInfoDesc, containerStatuses},
+		{&healthStatusCodeInfoDesc, healthStatuses},
+	} {
+		for i, status := range m.statuses {
+			ch <- prometheus.MustNewConstMetric(m.desc.Desc(map[string]string{"status": status, "code": strconv.Itoa(i)}), prometheus.GaugeValue, 1)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// defaultSensitiveEnvPatterns are used when the config file does not set
+// sensitive_env_patterns.
+var defaultSensitiveEnvPatterns = []string{"*PASSWORD*", "*PASSWD*", "*_TOKEN", "*SECRET*", "*_API_KEY", "*_PRIVATE_KEY", "*CREDENTIALS*"}
+
+// validateEnvPatterns checks the syntax of the patterns.
+func validateEnvPatterns(patterns []string) error {
+	for i, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("sensitive_env_patterns[%d]: invalid pattern %q: %w", i, p, err)
+		}
+	}
+	return nil
+}
+
+// countSensitiveEnv returns how many of the environment variable names
+// match one of the patterns, ignoring case.
+func countSensitiveEnv(names, patterns []string) int {
+	n := 0
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		for _, p := range patterns {
+			if ok, _ := path.Match(strings.ToUpper(p), name); ok {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestCountSensitiveEnv(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  int
+	}{
+		{nil, 0},
+		{[]string{"PATH", "HOME"}, 0},
+		{[]string{"DB_PASSWORD", "GITHUB_TOKEN", "PATH"}, 2},
+		{[]string{"db_password", "Aws_Secret_Access_Key"}, 2},
+		// A name is only counted once, even when matching several patterns.
+		{[]string{"SECRET_PASSWORD"}, 1},
+		// The suffix patterns do not match in the middle of a name.
+		{[]string{"TOKEN_FILE", "MY_API_KEY_PATH"}, 0},
+	}
+	for _, tt := range tests {
+		if got := countSensitiveEnv(tt.names, defaultSensitiveEnvPatterns); got != tt.want {
+			t.Errorf("countSensitiveEnv(%q) = %d, want %d", tt.names, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "strconv"
+
+// countedEvents are the container events counted by container_events_total.
+var countedEvents = []string{"create", "start", "restart", "die", "kill", "stop", "oom", "destroy"}
+
+// eventCounter counts the lifecycle events of every container, and of all
+// containers of every image, so destroyed containers still count. It also
+// counts the exits of every container by exit code. Containers are
+// identified by their counter key, see -collector.counters-key.
+type eventCounter struct {
+	containers map[string]map[string]float64
+	images     map[string]map[string]float64
+	exits      map[string]map[string]float64
+}
+
+// observe records a container event.
+func (t *eventCounter) observe(key, action string, attributes map[string]string) {
+	if !containsString(countedEvents, action) {
+		return
+	}
+	if t.containers == nil {
+		t.containers = map[string]map[string]float64{}
+		t.images = map[string]map[string]float64{}
+		t.exits = map[string]map[string]float64{}
+	}
+	if action == "die" {
+		// The exit code becomes a label value, it must be a number.
+		if code, err := strconv.Atoi(attributes["exitCode"]); err == nil {
+			if t.exits[key] == nil {
+				t.exits[key] = map[string]float64{}
+			}
+			t.exits[key][strconv.Itoa(code)]++
+		}
+	}
+	if t.containers[key] == nil {
+		t.containers[key] = map[string]float64{}
+	}
+	t.containers[key][action]++
+	if image := attributes["image"]; image != "" {
+		if t.images[image] == nil {
+			t.images[image] = map[string]float64{}
+		}
+		t.images[image][action]++
+	}
+}
+
+// count returns the number of events of the container with the action.
+func (t *eventCounter) count(key, action string) float64 {
+	return t.containers[key][action]
+}
+
+// exitCodes returns the number of exits of the container, by exit code.
+func (t *eventCounter) exitCodes(key string) map[string]float64 {
+	return t.exits[key]
+}
+
+// rename moves the counters of a container whose key changed.
+func (t *eventCounter) rename(from, to string) {
+	if counts, ok := t.containers[from]; ok {
+		delete(t.containers, from)
+		t.containers[to] = counts
+	}
+	if exits, ok := t.exits[from]; ok {
+		delete(t.exits, from)
+		t.exits[to] = exits
+	}
+}
+
+// forget drops the counters of a container. The counters of its image are
+// kept.
+func (t *eventCounter) forget(key string) {
+	delete(t.containers, key)
+	delete(t.exits, key)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// eventsHeartbeatInterval is how often the event loop reports progress to
+// its supervisor while no event arrives.
+const eventsHeartbeatInterval = 10 * time.Second
+
+// eventRefreshActions are the container events after which the container is
+// inspected again. exec_* events, sent for every healthcheck, are ignored.
+var eventRefreshActions = map[string]bool{
+	"create":        true,
+	"start":         true,
+	"restart":       true,
+	"die":           true,
+	"stop":          true,
+	"kill":          true,
+	"oom":           true,
+	"pause":         true,
+	"unpause":       true,
+	"rename":        true,
+	"update":        true,
+	"health_status": true,
+}
+
+// eventAction returns the action of an event without its attributes, such as
+// "health_status" for "health_status: healthy".
+func eventAction(msg events.Message) string {
+	action := msg.Action
+	if i := strings.IndexByte(action, ':'); i >= 0 {
+		action = action[:i]
+	}
+	return action
+}
+
+// eventTime returns when an event happened, or now for events without a
+// time.
+func eventTime(msg events.Message) time.Time {
+	if msg.TimeNano == 0 {
+		return time.Now()
+	}
+	return time.Unix(0, msg.TimeNano)
+}
+
+// watchEvents follows the docker event stream and keeps the cache up to date
+// between full refreshes, so scrapes reflect state changes immediately. It
+// returns when the stream fails, for its supervisor to restart it.
+func (c *dockerHealthCollector) watchEvents(ctx context.Context, heartbeat func()) {
+	f := filters.NewArgs(filters.Arg("type", events.ContainerEventType))
+	msgs, errs := c.containerClient.Events(ctx, types.EventsOptions{Filters: f})
+	// Events may have been missed while the stream was down.
+	c.invalidate()
+
+	ticker := time.NewTicker(eventsHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-msgs:
+			c.handleEvent(ctx, msg)
+			heartbeat()
+		case err := <-errs:
+			if ctx.Err() == nil {
+				errorLogger.Log("message", fmt.Sprintf("Docker event stream failed: %v", err))
+			}
+			return
+		case <-ticker.C:
+			heartbeat()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *dockerHealthCollector) handleEvent(ctx context.Context, msg events.Message) {
+	id := msg.Actor.ID
+	if id == "" || !c.shard.contains(id) {
+		return
+	}
+	c.mu.Lock()
+	key := c.counterKey(id, msg.Actor.Attributes["name"])
+	c.restarts.observe(key, msg.Action, msg.Actor.Attributes, eventTime(msg))
+	c.health.observe(key, msg.Action)
+	c.eventCounts.observe(key, eventAction(msg), msg.Actor.Attributes)
+	switch {
+	case msg.Action == "rename" && c.countersByName:
+		oldKey := strings.TrimPrefix(msg.Actor.Attributes["oldName"], "/")
+		c.restarts.rename(oldKey, key)
+		c.health.rename(oldKey, key)
+		c.eventCounts.rename(oldKey, key)
+	case msg.Action == "destroy" && !c.countersByName:
+		c.restarts.forget(key)
+		c.health.forget(key)
+		c.eventCounts.forget(key)
+	}
+	c.mu.Unlock()
+
+	switch action := eventAction(msg); {
+	case action == "destroy":
+		c.patch(id, nil)
+	case eventRefreshActions[action]:
+		c.reinspect(ctx, id)
+	}
+}
+
+// counterKey returns the key of the counters of a container. Keyed by name,
+// counters carry over to a new container with the same name, such as a
+// redeployed service.
+func (c *dockerHealthCollector) counterKey(id, name string) string {
+	if c.countersByName {
+		return strings.TrimPrefix(name, "/")
+	}
+	return id
+}
+
+// reinspect inspects a single container and updates it in the cache.
+func (c *dockerHealthCollector) reinspect(ctx context.Context, id string) {
+	info, err := c.inspect(ctx, id)
+	if client.IsErrNotFound(err) {
+		c.patch(id, nil)
+		return
+	}
+	if err != nil {
+		warnLogger.Log("message", fmt.Sprintf("Failed to inspect container %s after an event: %v", id, err))
+		return
+	}
+	state := newContainerState(&info)
+	if c.processes {
+		state.Processes = c.countProcesses(ctx, &info)
+	}
+	state.ImageDetails = c.imageDetails(ctx, state.ImageID)
+	c.patch(id, &state)
+}
+
+// patch replaces the container with the given ID in the cache, or removes it
+// if info is nil. The cache is copied, as a refresh may be reading it.
+func (c *dockerHealthCollector) patch(id string, info *containerState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight != nil {
+		// The refresh in progress may overwrite this change, it is applied
+		// again once the refresh is done.
+		c.dirty[id] = true
+	}
+	previous := c.cachedContainers()
+	cache := make([]containerState, 0, len(previous)+1)
+	for _, cached := range previous {
+		if cached.ID != id {
+			cache = append(cache, cached)
+		}
+	}
+	if info != nil {
+		cache = append(cache, *info)
+	}
+	c.setCache(cache, time.Now())
+}
+
+// invalidate makes the next scrape refresh the whole cache.
+func (c *dockerHealthCollector) invalidate() {
+	c.mu.Lock()
+	c.lastseen = time.Time{}
+	c.mu.Unlock()
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprType is the static type of an expression.
+type exprType int
+
+const (
+	boolType exprType = iota
+	numberType
+	stringType
+)
+
+func (t exprType) String() string {
+	switch t {
+	case boolType:
+		return "bool"
+	case numberType:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+// exprValue holds the result of evaluating an expression. Only the field
+// matching the expression type is set.
+type exprValue struct {
+	b bool
+	n float64
+	s string
+}
+
+// exprEnv resolves identifiers while an expression is evaluated.
+type exprEnv interface {
+	lookup(name string) exprValue
+}
+
+type exprNode struct {
+	typ  exprType
+	eval func(env exprEnv) exprValue
+}
+
+// boolExpr is a compiled boolean expression such as
+// `running && !healthy && restartcount > 3`.
+type boolExpr struct {
+	src  string
+	node exprNode
+}
+
+func (e *boolExpr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against env.
+func (e *boolExpr) Eval(env exprEnv) bool {
+	return e.node.eval(env).b
+}
+
+// compileBoolExpr parses src and type checks it against idents, which maps
+// every identifier usable in the expression to its type.
+//
+// The grammar supports the operators ||, &&, !, ==, !=, <, <=, >, >=,
+// parentheses, numbers, double-quoted strings and the literals true and false.
+func compileBoolExpr(src string, idents map[string]exprType) (*boolExpr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, idents: idents}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	if node.typ != boolType {
+		return nil, fmt.Errorf("expression must be bool, got %s", node.typ)
+	}
+	return &boolExpr{src: src, node: node}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenizeExpr(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		r := rune(src[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j], i})
+			i = j
+		case unicode.IsDigit(r) || r == '.':
+			j := i + 1
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, src[i:j], i})
+			i = j
+		case r == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{tokString, src[i : j+1], i})
+			i = j + 1
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", r, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "end of expression", len(src)}), nil
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+	idents map[string]exprType
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) acceptOp(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for p.acceptOp("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if left.typ != boolType || right.typ != boolType {
+			return exprNode{}, fmt.Errorf("operator || requires bool operands")
+		}
+		l, r := left.eval, right.eval
+		left = exprNode{boolType, func(env exprEnv) exprValue {
+			return exprValue{b: l(env).b || r(env).b}
+		}}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for p.acceptOp("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if left.typ != boolType || right.typ != boolType {
+			return exprNode{}, fmt.Errorf("operator && requires bool operands")
+		}
+		l, r := left.eval, right.eval
+		left = exprNode{boolType, func(env exprEnv) exprValue {
+			return exprValue{b: l(env).b && r(env).b}
+		}}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.acceptOp("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if operand.typ != boolType {
+			return exprNode{}, fmt.Errorf("operator ! requires a bool operand")
+		}
+		o := operand.eval
+		return exprNode{boolType, func(env exprEnv) exprValue {
+			return exprValue{b: !o(env).b}
+		}}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return left, nil
+	}
+	switch tok.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	if left.typ != right.typ {
+		return exprNode{}, fmt.Errorf("cannot compare %s with %s at offset %d", left.typ, right.typ, tok.pos)
+	}
+	if left.typ == boolType && tok.text != "==" && tok.text != "!=" {
+		return exprNode{}, fmt.Errorf("operator %s is not defined for bool at offset %d", tok.text, tok.pos)
+	}
+	return exprNode{boolType, compare(tok.text, left, right)}, nil
+}
+
+func compare(op string, left, right exprNode) func(env exprEnv) exprValue {
+	l, r, typ := left.eval, right.eval, left.typ
+	return func(env exprEnv) exprValue {
+		lv, rv := l(env), r(env)
+		var cmp int
+		switch typ {
+		case boolType:
+			if lv.b != rv.b {
+				cmp = 1
+			}
+		case numberType:
+			switch {
+			case lv.n < rv.n:
+				cmp = -1
+			case lv.n > rv.n:
+				cmp = 1
+			}
+		case stringType:
+			cmp = strings.Compare(lv.s, rv.s)
+		}
+		switch op {
+		case "==":
+			return exprValue{b: cmp == 0}
+		case "!=":
+			return exprValue{b: cmp != 0}
+		case "<":
+			return exprValue{b: cmp < 0}
+		case "<=":
+			return exprValue{b: cmp <= 0}
+		case ">":
+			return exprValue{b: cmp > 0}
+		default:
+			return exprValue{b: cmp >= 0}
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return exprNode{}, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return exprNode{numberType, func(exprEnv) exprValue { return exprValue{n: n} }}, nil
+	case tokString:
+		s, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return exprNode{}, fmt.Errorf("invalid string %s at offset %d", tok.text, tok.pos)
+		}
+		return exprNode{stringType, func(exprEnv) exprValue { return exprValue{s: s} }}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			b := tok.text == "true"
+			return exprNode{boolType, func(exprEnv) exprValue { return exprValue{b: b} }}, nil
+		}
+		typ, ok := p.idents[tok.text]
+		if !ok {
+			return exprNode{}, fmt.Errorf("unknown identifier %q at offset %d", tok.text, tok.pos)
+		}
+		name := tok.text
+		return exprNode{typ, func(env exprEnv) exprValue { return env.lookup(name) }}, nil
+	case tokOp:
+		if tok.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return exprNode{}, err
+			}
+			if !p.acceptOp(")") {
+				return exprNode{}, fmt.Errorf("missing ) at offset %d", p.peek().pos)
+			}
+			return node, nil
+		}
+	}
+	return exprNode{}, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
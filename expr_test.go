@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testEnv resolves identifiers from a map.
+type testEnv map[string]exprValue
+
+func (e testEnv) lookup(name string) exprValue {
+	return e[name]
+}
+
+var testIdents = map[string]exprType{
+	"running":      boolType,
+	"healthy":      boolType,
+	"restartcount": numberType,
+	"name":         stringType,
+}
+
+func TestCompileBoolExpr(t *testing.T) {
+	env := testEnv{
+		"running":      {b: true},
+		"healthy":      {b: false},
+		"restartcount": {n: 4},
+		"name":         {s: "web"},
+	}
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"running", true},
+		{"!running", false},
+		{"true", true},
+		// && binds tighter than ||.
+		{"true || false && false", true},
+		{"false && true || true", true},
+		{"(true || false) && false", false},
+		// ! binds tighter than && and ||.
+		{"!healthy && running", true},
+		{"!running || running", true},
+		{"!(running || healthy)", false},
+		{"!!running", true},
+		// Comparisons bind tighter than the logical operators.
+		{"restartcount > 3 && running", true},
+		{"running && restartcount >= 5 || name == \"web\"", true},
+		{"restartcount == 4", true},
+		{"restartcount != 4", false},
+		{"restartcount < 4.5", true},
+		{"restartcount <= 3", false},
+		{"name != \"db\"", true},
+		{"name < \"x\"", true},
+		{`name == "w\"eb"`, false},
+		{"running == true", true},
+		{"healthy != false", false},
+	}
+	for _, tt := range tests {
+		expr, err := compileBoolExpr(tt.src, testIdents)
+		if err != nil {
+			t.Errorf("compileBoolExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if got := expr.Eval(env); got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.src, got, tt.want)
+		}
+		if expr.String() != tt.src {
+			t.Errorf("String() = %q, want %q", expr.String(), tt.src)
+		}
+	}
+}
+
+func TestCompileBoolExprErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", `unexpected "end of expression" at offset 0`},
+		{"restartcount", "expression must be bool, got number"},
+		{"name", "expression must be bool, got string"},
+		{"restartcount && running", "operator && requires bool operands"},
+		{"running || name", "operator || requires bool operands"},
+		{"!restartcount", "operator ! requires a bool operand"},
+		{"name == 3", "cannot compare string with number at offset 5"},
+		{"running == restartcount", "cannot compare bool with number at offset 8"},
+		{"running < true", "operator < is not defined for bool at offset 8"},
+		{`name == "web`, "unterminated string at offset 8"},
+		{`name == "web\"`, "unterminated string at offset 8"},
+		{"stopped", `unknown identifier "stopped" at offset 0`},
+		{"running running", `unexpected "running" at offset 8`},
+		{"(running", "missing ) at offset 8"},
+		{"running)", `unexpected ")" at offset 7`},
+		{"running &", `unexpected character '&' at offset 8`},
+		{"restartcount > 1.2.3", `invalid number "1.2.3" at offset 15`},
+		{"restartcount > 3 > 2", `unexpected ">" at offset 17`},
+	}
+	for _, tt := range tests {
+		_, err := compileBoolExpr(tt.src, testIdents)
+		if err == nil {
+			t.Errorf("compileBoolExpr(%q) succeeded, want %q", tt.src, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("compileBoolExpr(%q) = %q, want %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+var exitCodeRegexp = regexp.MustCompile(`^Exited \((-?\d+)\)`)
+
+// containerFromSummary builds the state of a container from its entry in
+// the container list, for -collector.fast. The health status and exit code
+// are parsed from the status text, such as "Up 5 minutes (healthy)" or
+// "Exited (1) 2 hours ago", when healthInList tells the daemon reports them
+// there. The mounts, published ports and networks are listed too. Start and
+// finish times, the restart count, the OOM flag and the rest of the
+// configuration are not available.
+func containerFromSummary(container types.Container, healthInList bool) containerState {
+	s := containerState{
+		ID:      container.ID,
+		Image:   container.Image,
+		ImageID: container.ImageID,
+		Created: time.Unix(container.Created, 0).UTC().Format(time.RFC3339Nano),
+		Labels:  container.Labels,
+		Status:  container.State,
+		Health:  "none",
+		Mounts:  newMounts(container.Mounts),
+		Ports:   summaryPorts(container.Ports),
+	}
+	if container.NetworkSettings != nil {
+		s.Networks = newAttachedNetworks(container.NetworkSettings.Networks)
+	}
+	if len(container.Names) > 0 {
+		s.Name = strings.TrimPrefix(container.Names[0], "/")
+	}
+	switch {
+	case !healthInList:
+		// Healthchecks appeared with API 1.24, so containers of older
+		// daemons have none.
+	case strings.HasSuffix(container.Status, "(healthy)"):
+		s.Health = types.Healthy
+	case strings.HasSuffix(container.Status, "(unhealthy)"):
+		s.Health = types.Unhealthy
+	case strings.HasSuffix(container.Status, "(health: starting)"):
+		s.Health = types.Starting
+	}
+	if m := exitCodeRegexp.FindStringSubmatch(container.Status); m != nil {
+		s.ExitCode, _ = strconv.Atoi(m[1])
+	}
+	return s
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	tcontainer "github.com/docker/docker/api/types/container"
+)
+
+// hostSettings is the part of the host configuration of a container the
+// collector uses, for security and resource audits.
+type hostSettings struct {
+	Privileged     bool `json:"privileged"`
+	ReadonlyRootfs bool `json:"readonly_rootfs"`
+	// CapAdd are the added capabilities, see capabilityNames.
+	CapAdd      []string      `json:"cap_add,omitempty"`
+	SecurityOpt []securityOpt `json:"security_opt,omitempty"`
+
+	// Memory limits in bytes, as configured. 0 is no limit, and -1 for
+	// MemorySwap unlimited swap.
+	Memory            int64 `json:"memory,omitempty"`
+	MemorySwap        int64 `json:"memory_swap,omitempty"`
+	MemoryReservation int64 `json:"memory_reservation,omitempty"`
+
+	// CPUQuota is the CPU time in microseconds the container may use per
+	// CPUPeriod, 0 if unlimited.
+	CPUQuota   int64  `json:"cpu_quota,omitempty"`
+	CPUPeriod  int64  `json:"cpu_period,omitempty"`
+	CPUShares  int64  `json:"cpu_shares,omitempty"`
+	CpusetCpus string `json:"cpuset_cpus,omitempty"`
+	CpusetMems string `json:"cpuset_mems,omitempty"`
+
+	// Ulimits are the ulimits configured for the container, the defaults
+	// of the daemon are not included.
+	Ulimits []ulimit `json:"ulimits,omitempty"`
+
+	// LogMaxSize and LogMaxFile are the rotation options of the log driver,
+	// empty if unset.
+	LogDriver  string `json:"log_driver,omitempty"`
+	LogMaxSize string `json:"log_max_size,omitempty"`
+	LogMaxFile string `json:"log_max_file,omitempty"`
+
+	Devices []device `json:"devices,omitempty"`
+}
+
+// device is a device of the host passed through to a container.
+type device struct {
+	PathOnHost      string `json:"path_on_host"`
+	PathInContainer string `json:"path_in_container"`
+	// Permissions are the cgroup permissions, such as rwm.
+	Permissions string `json:"permissions"`
+}
+
+type ulimit struct {
+	Name string `json:"name"`
+	Soft int64  `json:"soft"`
+	Hard int64  `json:"hard"`
+}
+
+// securityOpt is a security option of a container, such as the seccomp
+// profile or an AppArmor or SELinux setting.
+type securityOpt struct {
+	Option string `json:"option"`
+	Value  string `json:"value"`
+}
+
+// Docker uses these for the CPU settings left unset.
+const (
+	defaultCPUPeriod = 100000
+	defaultCPUShares = 1024
+)
+
+func newHostSettings(hc *tcontainer.HostConfig) *hostSettings {
+	s := &hostSettings{
+		Privileged:     hc.Privileged,
+		ReadonlyRootfs: hc.ReadonlyRootfs,
+		CapAdd:         capabilityNames(hc.CapAdd),
+		SecurityOpt:    securityOpts(hc.SecurityOpt),
+
+		Memory:            hc.Memory,
+		MemorySwap:        hc.MemorySwap,
+		MemoryReservation: hc.MemoryReservation,
+
+		CPUQuota:   hc.CPUQuota,
+		CPUPeriod:  hc.CPUPeriod,
+		CPUShares:  hc.CPUShares,
+		CpusetCpus: hc.CpusetCpus,
+		CpusetMems: hc.CpusetMems,
+
+		LogDriver:  hc.LogConfig.Type,
+		LogMaxSize: hc.LogConfig.Config["max-size"],
+		LogMaxFile: hc.LogConfig.Config["max-file"],
+	}
+	if s.CPUPeriod == 0 {
+		s.CPUPeriod = defaultCPUPeriod
+	}
+	if s.CPUQuota == 0 && hc.NanoCPUs > 0 {
+		// --cpus sets a quota over the default period.
+		s.CPUQuota = hc.NanoCPUs * defaultCPUPeriod / 1e9
+	}
+	if s.CPUShares == 0 {
+		s.CPUShares = defaultCPUShares
+	}
+	for _, d := range hc.Devices {
+		s.Devices = append(s.Devices, device{
+			PathOnHost:      d.PathOnHost,
+			PathInContainer: d.PathInContainer,
+			Permissions:     d.CgroupPermissions,
+		})
+	}
+	for _, u := range hc.Ulimits {
+		if u != nil {
+			s.Ulimits = append(s.Ulimits, ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+		}
+	}
+	return s
+}
+
+// securityOpts parses security options, given as option=value or in the
+// legacy option:value form. Options without value, such as
+// no-new-privileges, get true. The docker CLI passes seccomp profiles by
+// content, they get custom. Duplicates are dropped.
+func securityOpts(opts []string) []securityOpt {
+	var parsed []securityOpt
+	for _, opt := range opts {
+		o := securityOpt{Option: opt, Value: "true"}
+		if i := strings.IndexAny(opt, "=:"); i >= 0 {
+			o.Option, o.Value = opt[:i], opt[i+1:]
+		}
+		if o.Option == "seccomp" && strings.HasPrefix(strings.TrimSpace(o.Value), "{") {
+			o.Value = "custom"
+		}
+		if !containsSecurityOpt(parsed, o) {
+			parsed = append(parsed, o)
+		}
+	}
+	return parsed
+}
+
+// capabilityNames normalizes capabilities to their upper case name without
+// the CAP_ prefix, such as SYS_ADMIN, as docker accepts both forms. The names
+// are sorted and deduplicated.
+func capabilityNames(caps []string) []string {
+	var names []string
+	for _, c := range caps {
+		name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(c)), "CAP_")
+		if name != "" && !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsSecurityOpt(opts []securityOpt, opt securityOpt) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// imageDetails is the part of an image inspection the collector uses.
+type imageDetails struct {
+	// Digest is the content digest of the image in its registry, empty for
+	// images that were built locally and never pushed or pulled.
+	Digest string `json:"digest,omitempty"`
+	// Created is the build time of the image.
+	Created string `json:"created,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+}
+
+// imageCache holds the details of the images of the containers by image ID.
+// Images are immutable, so each is only inspected once while containers use
+// it.
+type imageCache struct {
+	mu     sync.Mutex
+	images map[string]imageDetails
+}
+
+func (t *imageCache) get(id string) (imageDetails, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	details, ok := t.images[id]
+	return details, ok
+}
+
+func (t *imageCache) set(id string, details imageDetails) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.images == nil {
+		t.images = map[string]imageDetails{}
+	}
+	t.images[id] = details
+}
+
+// retain forgets the images missing from ids.
+func (t *imageCache) retain(ids map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id := range t.images {
+		if !ids[id] {
+			delete(t.images, id)
+		}
+	}
+}
+
+// imageDetails returns the details of the image with the given ID, from the
+// cache or by inspecting it. Failed inspections, such as for an image whose
+// tags were removed while containers use it, give empty details and are
+// retried on the next collection.
+func (c *dockerHealthCollector) imageDetails(ctx context.Context, id string) imageDetails {
+	if !c.inspectImages || id == "" {
+		return imageDetails{}
+	}
+	if details, ok := c.images.get(id); ok {
+		return details
+	}
+	inspect, _, err := c.containerClient.ImageInspectWithRaw(ctx, id)
+	if err != nil {
+		return imageDetails{}
+	}
+	details := imageDetails{Created: inspect.Created, Size: inspect.Size}
+	for _, repoDigest := range inspect.RepoDigests {
+		if _, digest, ok := strings.Cut(repoDigest, "@"); ok {
+			details.Digest = digest
+			break
+		}
+	}
+	c.images.set(id, details)
+	return details
+}
+
+// imageIDs returns the IDs of the images of the containers.
+func imageIDs(cache []containerState) map[string]bool {
+	ids := make(map[string]bool, len(cache))
+	for i := range cache {
+		ids[cache[i].ImageID] = true
+	}
+	return ids
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	containersRunningDesc = newDescSource(
+		"docker_containers_running",
+		"Number of running containers, as reported by the docker daemon.")
+	containersPausedDesc = newDescSource(
+		"docker_containers_paused",
+		"Number of paused containers, as reported by the docker daemon.")
+	containersStoppedDesc = newDescSource(
+		"docker_containers_stopped",
+		"Number of stopped containers, as reported by the docker daemon.")
+	imagesDesc = newDescSource(
+		"docker_images",
+		"Number of images of the docker daemon.")
+	ncpuDesc = newDescSource(
+		"docker_ncpu",
+		"Number of CPUs of the docker host.")
+	memTotalDesc = newDescSource(
+		"docker_mem_total_bytes",
+		"Memory of the docker host.")
+	storageDriverInfoDesc = newDescSource(
+		"docker_storage_driver_info",
+		"Storage driver and data root directory of the docker daemon. The value is always 1.")
+	storageDriverDeprecatedDesc = newDescSource(
+		"docker_storage_driver_deprecated",
+		"Whether the storage driver of the docker daemon is deprecated, so the host needs to migrate to another one.")
+	engineInfoDesc = newDescSource(
+		"docker_engine_info",
+		"Versions of the docker engine, of its components and of its host. The value is always 1.")
+)
+
+// deprecatedStorageDrivers are the storage drivers deprecated, and removed
+// from recent engines, in favor of overlay2.
+var deprecatedStorageDrivers = []string{"aufs", "devicemapper", "overlay"}
+
+// infoCollector exports the summary of the docker daemon from its info
+// endpoint, as shown by docker info, and its versions from the version
+// endpoint. The results are reused for cachePeriod, and the daemon is not
+// called while the circuit breaker of state is open.
+type infoCollector struct {
+	cli         *client.Client
+	cachePeriod time.Duration
+	state       *dockerHealthCollector
+
+	mu       sync.Mutex
+	metrics  []prometheus.Metric
+	lastseen time.Time
+}
+
+func (c *infoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- containersRunningDesc.Desc(nil)
+	ch <- containersPausedDesc.Desc(nil)
+	ch <- containersStoppedDesc.Desc(nil)
+	ch <- imagesDesc.Desc(nil)
+	ch <- ncpuDesc.Desc(nil)
+	ch <- memTotalDesc.Desc(nil)
+	ch <- storageDriverInfoDesc.Desc(nil)
+	ch <- storageDriverDeprecatedDesc.Desc(nil)
+	ch <- engineInfoDesc.Desc(nil)
+}
+
+func (c *infoCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metrics == nil || time.Since(c.lastseen) >= c.cachePeriod || freshRequested(ctx) {
+		if c.state.circuitOpen() {
+			return errCircuitOpen
+		}
+		metrics, err := c.collect(ctx)
+		if err != nil {
+			return err
+		}
+		c.metrics, c.lastseen = metrics, time.Now()
+	}
+	for _, m := range c.metrics {
+		ch <- m
+	}
+	return nil
+}
+
+func (c *infoCollector) collect(ctx context.Context) ([]prometheus.Metric, error) {
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var metrics []prometheus.Metric
+	for _, m := range []struct {
+		desc  *descSource
+		value float64
+	}{
+		{&containersRunningDesc, float64(info.ContainersRunning)},
+		{&containersPausedDesc, float64(info.ContainersPaused)},
+		{&containersStoppedDesc, float64(info.ContainersStopped)},
+		{&imagesDesc, float64(info.Images)},
+		{&ncpuDesc, float64(info.NCPU)},
+		{&memTotalDesc, float64(info.MemTotal)},
+	} {
+		metrics = append(metrics, prometheus.MustNewConstMetric(m.desc.Desc(nil), prometheus.GaugeValue, m.value))
+	}
+	metrics = append(metrics,
+		prometheus.MustNewConstMetric(storageDriverInfoDesc.Desc(map[string]string{"driver": info.Driver, "data_root": info.DockerRootDir}), prometheus.GaugeValue, 1),
+		prometheus.MustNewConstMetric(storageDriverDeprecatedDesc.Desc(nil), prometheus.GaugeValue, b2f(containsString(deprecatedStorageDrivers, info.Driver))))
+
+	version, err := c.cli.ServerVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labels := prometheus.Labels{
+		"version":            version.Version,
+		"api_version":        version.APIVersion,
+		"os":                 info.OperatingSystem,
+		"kernel":             info.KernelVersion,
+		"containerd_version": "",
+		"runc_version":       "",
+	}
+	// The versions of the components are reported from API 1.35.
+	for _, component := range version.Components {
+		switch component.Name {
+		case "containerd":
+			labels["containerd_version"] = component.Version
+		case "runc":
+			labels["runc_version"] = component.Version
+		}
+	}
+	return append(metrics, prometheus.MustNewConstMetric(engineInfoDesc.Desc(labels), prometheus.GaugeValue, 1)), nil
+}
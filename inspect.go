@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	tcontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// inspectSections are the sections of the inspect response that can be
+// selected with -inspect.fields. The ID, name, image, creation time, labels,
+// log path, user and healthcheck configuration of a container are always
+// decoded.
+var inspectSections = []string{"state", "health", "restartcount", "config", "hostconfig", "mounts", "networksettings"}
+
+// inspectFields is a set of inspectSections. A nil set selects everything.
+type inspectFields map[string]bool
+
+// has reports whether the section is decoded.
+func (f inspectFields) has(section string) bool {
+	return f == nil || f[section]
+}
+
+func parseInspectFields(s string) (inspectFields, error) {
+	if s == "" || s == "all" {
+		return nil, nil
+	}
+	fields := inspectFields{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if !containsString(inspectSections, field) {
+			return nil, fmt.Errorf("unknown inspect field %q, must be one of %s or all", field, strings.Join(inspectSections, ","))
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+// inspectProjected inspects a container like ContainerInspect, but only
+// decodes and retains the selected fields. Skipping the large sections such
+// as HostConfig and NetworkSettings substantially reduces allocations on
+// hosts with many containers. With size, the daemon also computes the sizes
+// of the filesystem of the container.
+func inspectProjected(ctx context.Context, cli *client.Client, id string, fields inspectFields, size bool) (types.ContainerJSON, error) {
+	hostURL, err := client.ParseHostURL(cli.DaemonHost())
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	httpClient := cli.HTTPClient()
+	u := url.URL{Scheme: "http", Host: hostURL.Host, Path: hostURL.Path + "/v" + cli.ClientVersion() + "/containers/" + url.PathEscape(id) + "/json"}
+	if size {
+		u.RawQuery = url.Values{"size": {"1"}}.Encode()
+	}
+	if tr, ok := baseTransport(httpClient.Transport).(*http.Transport); ok && tr.TLSClientConfig != nil {
+		u.Scheme = "https"
+	}
+	if hostURL.Scheme == "unix" || hostURL.Scheme == "npipe" {
+		// The transport dials the socket itself, like the docker client does.
+		u.Host = "docker"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		err := fmt.Errorf("error response from daemon: %s", body.Message)
+		if resp.StatusCode == http.StatusNotFound {
+			return types.ContainerJSON{}, errdefs.NotFound(err)
+		}
+		return types.ContainerJSON{}, err
+	}
+
+	return decodeProjected(resp.Body, fields)
+}
+
+// projectedInspect declares the sections of the inspect response, so the
+// decoder skips the other ones without copying them.
+type projectedInspect struct {
+	ID              string `json:"Id"`
+	Name            string
+	Created         string
+	Image           string
+	SizeRw          *int64
+	SizeRootFs      *int64
+	LogPath         string
+	HostnamePath    string
+	Config          projectedSection
+	State           projectedSection
+	RestartCount    projectedSection
+	HostConfig      projectedSection
+	Mounts          projectedSection
+	NetworkSettings projectedSection
+}
+
+// projectedSection is a section of the inspect response, decoded into v, or
+// skipped when v is nil.
+type projectedSection struct {
+	v interface{}
+}
+
+func (s *projectedSection) UnmarshalJSON(data []byte) error {
+	if s.v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, s.v)
+}
+
+func decodeProjected(r io.Reader, fields inspectFields) (types.ContainerJSON, error) {
+	base := &types.ContainerJSONBase{}
+	info := types.ContainerJSON{ContainerJSONBase: base}
+	var p projectedInspect
+
+	var config struct {
+		Image       string
+		Labels      map[string]string
+		User        string
+		Healthcheck *tcontainer.HealthConfig
+	}
+	if fields["config"] {
+		p.Config.v = &info.Config
+	} else {
+		p.Config.v = &config
+	}
+	var state struct {
+		Health *types.Health
+	}
+	switch {
+	case fields["state"]:
+		p.State.v = &base.State
+	case fields["health"]:
+		p.State.v = &state
+	}
+	if fields["restartcount"] {
+		p.RestartCount.v = &base.RestartCount
+	}
+	if fields["hostconfig"] {
+		p.HostConfig.v = &base.HostConfig
+	}
+	if fields["mounts"] {
+		p.Mounts.v = &info.Mounts
+	}
+	if fields["networksettings"] {
+		p.NetworkSettings.v = &info.NetworkSettings
+	}
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	base.ID = p.ID
+	base.Name = p.Name
+	base.Created = p.Created
+	base.Image = p.Image
+	base.SizeRw = p.SizeRw
+	base.SizeRootFs = p.SizeRootFs
+	base.LogPath = p.LogPath
+	base.HostnamePath = p.HostnamePath
+	if !fields["config"] {
+		info.Config = &tcontainer.Config{Image: config.Image, Labels: config.Labels, User: config.User, Healthcheck: config.Healthcheck}
+	}
+	switch {
+	case fields["state"]:
+		if base.State != nil && !fields["health"] {
+			base.State.Health = nil
+		}
+	case fields["health"]:
+		base.State = &types.ContainerState{Health: state.Health}
+	}
+	return info, nil
+}
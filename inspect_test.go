@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	tcontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// inspectFixture returns the inspect response of a container with a large
+// configuration, host configuration and network settings.
+func inspectFixture(t testing.TB) []byte {
+	t.Helper()
+	env := make([]string, 100)
+	for i := range env {
+		env[i] = fmt.Sprintf("VARIABLE_%d=value of the variable %d", i, i)
+	}
+	mounts := make([]types.MountPoint, 20)
+	for i := range mounts {
+		mounts[i] = types.MountPoint{Type: "bind", Source: fmt.Sprintf("/srv/data/%d", i), Destination: fmt.Sprintf("/data/%d", i), RW: true}
+	}
+	networks := map[string]*network.EndpointSettings{}
+	for i := 0; i < 5; i++ {
+		networks[fmt.Sprintf("network-%d", i)] = &network.EndpointSettings{IPAddress: fmt.Sprintf("172.18.0.%d", i+2), MacAddress: "02:42:ac:12:00:02"}
+	}
+	info := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:      "aaa111",
+			Name:    "/web",
+			Created: "2023-01-02T03:04:05.123456789Z",
+			Image:   "sha256:0123456789abcdef",
+			LogPath: "/var/lib/docker/containers/aaa111/aaa111-json.log",
+			State: &types.ContainerState{
+				Status:    "running",
+				Running:   true,
+				Pid:       1234,
+				StartedAt: "2023-01-02T03:04:06Z",
+				Health:    &types.Health{Status: types.Healthy, FailingStreak: 0},
+			},
+			RestartCount: 3,
+			HostConfig: &tcontainer.HostConfig{
+				Binds:       []string{"/srv/data:/data"},
+				NetworkMode: "bridge",
+				CapAdd:      []string{"NET_ADMIN"},
+			},
+		},
+		Mounts: mounts,
+		Config: &tcontainer.Config{
+			Image:  "nginx:latest",
+			Env:    env,
+			Labels: map[string]string{"com.docker.compose.project": "demo", "team": "web"},
+			User:   "nginx",
+		},
+		NetworkSettings: &types.NetworkSettings{Networks: networks},
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeProjected(t *testing.T) {
+	data := inspectFixture(t)
+	var full types.ContainerJSON
+	if err := json.Unmarshal(data, &full); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fields string
+		check  func(t *testing.T, info types.ContainerJSON)
+	}{
+		{"state", func(t *testing.T, info types.ContainerJSON) {
+			if info.State == nil || info.State.Pid != 1234 || info.State.Health != nil {
+				t.Errorf("State = %+v, want the state without health", info.State)
+			}
+			if info.Config.Env != nil || info.HostConfig != nil || info.Mounts != nil || info.NetworkSettings != nil {
+				t.Error("sections not selected were decoded")
+			}
+		}},
+		{"health", func(t *testing.T, info types.ContainerJSON) {
+			if info.State == nil || info.State.Status != "" || !reflect.DeepEqual(info.State.Health, full.State.Health) {
+				t.Errorf("State = %+v, want the health only", info.State)
+			}
+		}},
+		{"restartcount", func(t *testing.T, info types.ContainerJSON) {
+			if info.RestartCount != 3 || info.State != nil {
+				t.Errorf("RestartCount = %d, State = %+v, want 3 and nil", info.RestartCount, info.State)
+			}
+		}},
+		{"config,hostconfig,mounts,networksettings", func(t *testing.T, info types.ContainerJSON) {
+			if !reflect.DeepEqual(info.Config, full.Config) {
+				t.Errorf("Config = %+v, want %+v", info.Config, full.Config)
+			}
+			if !reflect.DeepEqual(info.HostConfig, full.HostConfig) {
+				t.Errorf("HostConfig = %+v, want %+v", info.HostConfig, full.HostConfig)
+			}
+			if !reflect.DeepEqual(info.Mounts, full.Mounts) {
+				t.Errorf("Mounts = %+v, want %+v", info.Mounts, full.Mounts)
+			}
+			if !reflect.DeepEqual(info.NetworkSettings, full.NetworkSettings) {
+				t.Errorf("NetworkSettings = %+v, want %+v", info.NetworkSettings, full.NetworkSettings)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			fields, err := parseInspectFields(tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := decodeProjected(bytes.NewReader(data), fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.ID != "aaa111" || info.Name != "/web" || info.Created != full.Created || info.LogPath != full.LogPath {
+				t.Errorf("base = %+v, want the always decoded fields", info.ContainerJSONBase)
+			}
+			if info.Config == nil || info.Config.Image != "nginx:latest" || info.Config.User != "nginx" || !reflect.DeepEqual(info.Config.Labels, full.Config.Labels) {
+				t.Errorf("Config = %+v, want the image, user and labels", info.Config)
+			}
+			tt.check(t, info)
+		})
+	}
+}
+
+func TestDecodeProjectedInvalid(t *testing.T) {
+	for _, data := range []string{`{"Id": 1}`, `{"State": {"Pid": "x"}}`, `{"Id": "aaa111"`} {
+		if _, err := decodeProjected(bytes.NewReader([]byte(data)), inspectFields{"state": true}); err == nil {
+			t.Errorf("decodeProjected(%s) succeeded, want an error", data)
+		}
+	}
+}
+
+func BenchmarkDecodeProjected(b *testing.B) {
+	data := inspectFixture(b)
+	b.Run("ContainerJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var info types.ContainerJSON
+			if err := json.NewDecoder(bytes.NewReader(data)).Decode(&info); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, s := range []string{"state,health", "state,health,restartcount,mounts"} {
+		fields, err := parseInspectFields(s)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(s, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeProjected(bytes.NewReader(data), fields); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
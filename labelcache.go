@@ -0,0 +1,118 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labelNameCacheSize bounds the number of memoized label names. Label keys
+// rarely change, so this comfortably holds the keys of a large host.
+const labelNameCacheSize = 4096
+
+// labelNameCache memoizes the sanitized form of label names, evicting the
+// least recently used ones.
+type labelNameCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type labelNameEntry struct {
+	raw, sanitized string
+}
+
+func newLabelNameCache(size int) *labelNameCache {
+	return &labelNameCache{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// sanitize returns raw lowercased, with the characters invalid in label names
+// replaced by underscores.
+func (lc *labelNameCache) sanitize(raw string) string {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if e, ok := lc.entries[raw]; ok {
+		lc.order.MoveToFront(e)
+		return e.Value.(*labelNameEntry).sanitized
+	}
+	sanitized := labelNameRegexp.ReplaceAllLiteralString(strings.ToLower(raw), "_")
+	lc.entries[raw] = lc.order.PushFront(&labelNameEntry{raw, sanitized})
+	if lc.order.Len() > lc.size {
+		oldest := lc.order.Back()
+		lc.order.Remove(oldest)
+		delete(lc.entries, oldest.Value.(*labelNameEntry).raw)
+	}
+	return sanitized
+}
+
+// labelValuesInternSize bounds the number of interned label values.
+const labelValuesInternSize = 16384
+
+// interner deduplicates strings, so label values repeated across containers,
+// such as image names and compose projects, are only stored once. It starts
+// over once it holds size strings.
+type interner struct {
+	mu      sync.Mutex
+	size    int
+	strings map[string]string
+}
+
+func newInterner(size int) *interner {
+	return &interner{size: size, strings: map[string]string{}}
+}
+
+func (in *interner) intern(s string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if interned, ok := in.strings[s]; ok {
+		return interned
+	}
+	if len(in.strings) >= in.size {
+		in.strings = map[string]string{}
+	}
+	in.strings[s] = s
+	return s
+}
+
+// descCacheSize bounds the number of cached Descs. There is one per metric
+// and set of label names, which only grows with the variety of container
+// label keys.
+const descCacheSize = 4096
+
+// descLRU caches Descs by key, evicting the least recently used ones.
+type descLRU struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type descEntry struct {
+	key  string
+	desc *prometheus.Desc
+}
+
+func newDescLRU(size int) *descLRU {
+	return &descLRU{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// get returns the Desc cached for key, or the one built by build.
+func (dc *descLRU) get(key string, build func() *prometheus.Desc) *prometheus.Desc {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if e, ok := dc.entries[key]; ok {
+		dc.order.MoveToFront(e)
+		return e.Value.(*descEntry).desc
+	}
+	desc := build()
+	dc.entries[key] = dc.order.PushFront(&descEntry{key, desc})
+	if dc.order.Len() > dc.size {
+		oldest := dc.order.Back()
+		dc.order.Remove(oldest)
+		delete(dc.entries, oldest.Value.(*descEntry).key)
+	}
+	return desc
+}
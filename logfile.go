@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+)
+
+// logFile returns the path on the host of the file a container logs to, for
+// the json-file and local log drivers, or "" for the other drivers. Only the
+// current file is considered, not the ones already rotated.
+func logFile(base *types.ContainerJSONBase) string {
+	driver := ""
+	if base.HostConfig != nil {
+		driver = base.HostConfig.LogConfig.Type
+	}
+	switch driver {
+	case "", "json-file":
+		// Docker only reports the log path of the json-file driver.
+		return base.LogPath
+	case "local":
+		// The local driver logs to the directory of the container, which
+		// also holds its hostname file.
+		if base.HostnamePath == "" {
+			return ""
+		}
+		return filepath.Join(filepath.Dir(base.HostnamePath), "local-logs", "container.log")
+	default:
+		return ""
+	}
+}
+
+// logFileSize returns the size of a log file, read below the mount point of
+// the host root filesystem.
+func logFileSize(rootfs, path string) (int64, bool) {
+	if rootfs != "/" {
+		path = filepath.Join(rootfs, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	return info.Size(), true
+}
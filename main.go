@@ -2,35 +2,130 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"reflect"
 	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/docker/docker/api/types"
-	tcontainer "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-)
-
-const (
-	// cachePeriod indicates the period of time the collector will reuse the results of docker inspect.
-	cachePeriod = 1 * time.Second
+	"github.com/prometheus/common/model"
 )
 
 type dockerHealthCollector struct {
-	mu                 sync.Mutex
+	mu                 sync.RWMutex
 	containerClient    *client.Client
-	containerInfoCache []types.ContainerJSON
-	lastseen           time.Time
+	containerInfoCache []containerState
+	// compressed holds the cache instead of containerInfoCache with
+	// -collector.compress-cache, see cachedContainers. A new cache stays
+	// decoded until compressDecoded runs, see setCache.
+	compressed        *compressedCache
+	compress          bool
+	compressScheduled bool
+	lastseen          time.Time
+	inflight          *refreshCall
+	dirty             map[string]bool
+	// cachePeriod indicates the period of time the collector will reuse the results of docker inspect.
+	cachePeriod       time.Duration
+	removedTTL        time.Duration
+	removed           map[string]removedContainer
+	resyncInterval    time.Duration
+	pollInterval      time.Duration
+	pollTimeout       time.Duration
+	refreshTimeout    time.Duration
+	refreshErr        error
+	lastSuccess       time.Time
+	lastDuration      time.Duration
+	outageSince       time.Time
+	outageGracePeriod time.Duration
+	derivedMetrics    []derivedMetric
+	severityRules     []severityRule
+	sensitiveEnv      []string
+	shard             shard
+	inspectFields     inspectFields
+	inspectRetries    int
+	workers           int
+	incremental       bool
+	fast              bool
+	streaming         bool
+	// summaries are the containerSummary of the containers inspected by the
+	// previous refresh. Only refresh uses them.
+	summaries          map[string]string
+	breaker            circuitBreaker
+	routingLabelPrefix string
+	anonymize          bool
+	logUnhealthy       bool
+	processes          bool
+	sizes              bool
+	inspectImages      bool
+	images             imageCache
+	healthOutputInfo   bool
+	commandInfo        bool
+	mountInfo          bool
+	statuses           statusTracker
+	warmups            warmupTracker
+	starts             startTracker
+	procfs             string
+	// daemonVisible tells whether procfs shows the process of the daemon,
+	// checked once per collection for container_state_pid_missing.
+	daemonVisible  atomic.Bool
+	rootfs         string
+	restarts       restartTracker
+	restartTotals  restartTotals
+	health         healthTracker
+	eventCounts    eventCounter
+	events         bool
+	countersByName bool
+	snapshotFile   string
+	exportUptime   bool
+	stateEnum      bool
+	// snapshotSaved is when the snapshot file was last written.
+	snapshotSaved time.Time
+	// restored is whether the cache still holds the snapshot restored at
+	// startup.
+	restored bool
+
+	// Progress of the initial sync, see reportSyncProgress.
+	synced     atomic.Bool
+	syncDone   atomic.Int64
+	syncTotal  atomic.Int64
+	syncLogged time.Time
+
+	// Diagnostics, readable while the collector hangs.
+	cacheSize atomic.Int64
+	lastError atomic.Pointer[collectError]
+
+	// capabilities of the daemon, probed at startup and on reconnect.
+	capabilities atomic.Pointer[capabilities]
+
+	// Durations of the phases of the last collection, see collectPhases.
+	phaseDurations [len(collectPhases)]atomic.Int64
+
+	// generation changes whenever the cached state does, see -web.etag.
+	generation atomic.Int64
 }
 
 type descSource struct {
@@ -38,119 +133,1536 @@ type descSource struct {
 	help string
 }
 
+// builtinMetricNames are the names of the metrics of the exporter, which
+// derived metrics must not use.
+var builtinMetricNames = map[string]bool{}
+
+// newDescSource declares a metric of the exporter.
+func newDescSource(name, help string) descSource {
+	builtinMetricNames[name] = true
+	return descSource{name, help}
+}
+
 func (desc *descSource) Desc(labels prometheus.Labels) *prometheus.Desc {
 	return prometheus.NewDesc(desc.name, desc.help, nil, labels)
 }
 
+// descCache holds the Descs built by descSource.metric, keyed by metric name
+// and label names, so they are not built again for every container on every
+// scrape.
+var descCache = newDescLRU(descCacheSize)
+
+// metric returns a metric of desc with the labels of ls.
+func (desc *descSource) metric(valueType prometheus.ValueType, value float64, ls labelSet) (prometheus.Metric, error) {
+	key := desc.name + "\x00" + ls.key
+	d := descCache.get(key, func() *prometheus.Desc {
+		return prometheus.NewDesc(desc.name, desc.help, ls.names, nil)
+	})
+	return prometheus.NewConstMetric(d, valueType, value, ls.values...)
+}
+
+// labelSet holds label names and values in matching order. The container
+// labels are sorted by name, so containers with the same label names share
+// their Descs.
+type labelSet struct {
+	names  []string
+	values []string
+	key    string
+}
+
+func newLabelSet(labels map[string]string) labelSet {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, k := range names {
+		values[i] = labels[k]
+	}
+	return labelSet{names, values, strings.Join(names, "\x00")}
+}
+
+// value returns the value of the label with the given name.
+func (ls labelSet) value(name string) string {
+	for i, n := range ls.names {
+		if n == name {
+			return ls.values[i]
+		}
+	}
+	return ""
+}
+
+// with returns a copy of ls with an additional label.
+func (ls labelSet) with(name, value string) labelSet {
+	return labelSet{
+		names:  append(ls.names[:len(ls.names):len(ls.names)], name),
+		values: append(ls.values[:len(ls.values):len(ls.values)], value),
+		key:    ls.key + "\x00" + name,
+	}
+}
+
 var (
 	namespace        = "container_state_"
-	healthStatusDesc = descSource{
-		namespace + "health_status",
-		"Container health status."}
-	statusDesc = descSource{
-		namespace + "status",
-		"Container status."}
-	oomkilledDesc = descSource{
-		namespace + "oomkilled",
-		"Container was killed by OOMKiller."}
-	startedatDesc = descSource{
-		namespace + "startedat",
-		"Time when the Container started."}
-	finishedatDesc = descSource{
-		namespace + "finishedat",
-		"Time when the Container finished."}
-	restartcountDesc = descSource{
+	healthStatusDesc = newDescSource(
+		namespace+"health_status",
+		"Container health status.")
+	healthcheckConfiguredDesc = newDescSource(
+		namespace+"healthcheck_configured",
+		"Whether the Container has a healthcheck, configured for it or inherited from its image.")
+	healthcheckIntervalDesc = newDescSource(
+		"container_healthcheck_interval_seconds",
+		"Interval between the healthchecks of the container.")
+	healthcheckTimeoutDesc = newDescSource(
+		"container_healthcheck_timeout_seconds",
+		"Time after which a healthcheck of the container fails.")
+	healthcheckRetriesDesc = newDescSource(
+		"container_healthcheck_retries",
+		"Number of consecutive failed healthchecks after which the container is unhealthy.")
+	healthcheckStartPeriodDesc = newDescSource(
+		"container_healthcheck_start_period_seconds",
+		"Time after the start of the container during which failed healthchecks do not count.")
+	healthFailingStreakDesc = newDescSource(
+		namespace+"health_failing_streak",
+		"Number of consecutive failed healthchecks of the Container.")
+	healthLastExitCodeDesc = newDescSource(
+		namespace+"health_last_exit_code",
+		"Exit code of the last healthcheck of the Container.")
+	healthLastCheckDurationDesc = newDescSource(
+		namespace+"health_last_check_duration_seconds",
+		"Duration of the last healthcheck of the Container.")
+	healthLastCheckTimestampDesc = newDescSource(
+		namespace+"health_last_check_timestamp_seconds",
+		"Time when the last healthcheck of the Container ended, 0 if none ran yet.")
+	healthLastOutputInfoDesc = newDescSource(
+		namespace+"health_last_output_info",
+		"Output of the last healthcheck of the Container, truncated. The value is always 1.")
+	statusDesc = newDescSource(
+		namespace+"status",
+		"Container status.")
+	oomkilledDesc = newDescSource(
+		namespace+"oomkilled",
+		"Container was killed by OOMKiller.")
+	pidDesc = newDescSource(
+		namespace+"pid",
+		"PID of the main process of the Container on the host, 0 if it is not running.")
+	pidMissingDesc = newDescSource(
+		namespace+"pid_missing",
+		"Whether the main process of the running Container no longer exists on the host.")
+	fsRwDesc = newDescSource(
+		"container_fs_rw_bytes",
+		"Size of the files created or changed by the container in its writable layer.")
+	fsRootfsDesc = newDescSource(
+		"container_fs_rootfs_bytes",
+		"Size of all the files of the container, including its image.")
+	logFileSizeDesc = newDescSource(
+		"container_log_file_size_bytes",
+		"Size of the current log file of the container, for the json-file and local log drivers.")
+	processesDesc = newDescSource(
+		"container_processes",
+		"Number of processes running in the Container.")
+	createdatDesc = newDescSource(
+		namespace+"createdat",
+		"Time when the Container was created.")
+	startedatDesc = newDescSource(
+		namespace+"startedat",
+		"Time when the Container started.")
+	uptimeDesc = newDescSource(
+		namespace+"uptime_seconds",
+		"Seconds since the Container started, 0 if it is not running.")
+	finishedatDesc = newDescSource(
+		namespace+"finishedat",
+		"Time when the Container finished.")
+	exitcodeDesc = newDescSource(
+		namespace+"exitcode",
+		"Exit code of the Container when it last exited, 0 while it runs.")
+	errorInfoDesc = newDescSource(
+		namespace+"error_info",
+		"Error of the last start attempt of the Container, truncated. The value is always 1.")
+	restartcountDesc = newDescSource(
 		"container_restartcount",
-		"Number of times the container has been restarted"}
+		"Number of times the container has been restarted")
+	startLatencyDesc = newDescSource(
+		namespace+"start_latency_seconds",
+		"Seconds from the creation of the Container to its first start.")
+	timeToHealthyDesc = newDescSource(
+		namespace+"time_to_healthy_seconds",
+		"Seconds the Container took to become healthy after its last start.")
+	stateDurationDesc = newDescSource(
+		namespace+"duration_seconds",
+		"Seconds the Container has been in its current status.")
+	pausedDesc = newDescSource(
+		namespace+"paused_seconds",
+		"Seconds the Container has been paused, 0 if it is not paused.")
+	stuckRemovingDesc = newDescSource(
+		"container_stuck_removing_seconds",
+		"Seconds the container has been in the removing or dead status, 0 in any other status.")
+	restartsTotalDesc = newDescSource(
+		"container_restarts_total",
+		"Number of restarts of the containers with the name, continued when a container is recreated.")
+	restartsRecentDesc = newDescSource(
+		"container_restarts_recent",
+		"Number of restarts of the container observed from docker events within the window.")
+	eventsTotalDesc = newDescSource(
+		"container_events_total",
+		"Number of lifecycle events of the container observed from docker events, by action.")
+	imageEventsTotalDesc = newDescSource(
+		"container_image_events_total",
+		"Number of lifecycle events of the containers of the image observed from docker events, by action, including removed containers.")
+	exitsTotalDesc = newDescSource(
+		"container_exits_total",
+		"Number of exits of the container observed from docker events, by exit code.")
+	oomKillsDesc = newDescSource(
+		"container_oom_kills_total",
+		"Number of OOM kills of the container observed from docker events.")
+	restartCausesDesc = newDescSource(
+		"container_restart_causes_total",
+		"Number of restarts of the container observed from docker events, by probable cause.")
+	healthTransitionsDesc = newDescSource(
+		"container_health_transitions_total",
+		"Number of health status changes of the container observed from docker events.")
+	userInfoDesc = newDescSource(
+		"container_user_info",
+		"User the container runs as, empty for the default. The value is always 1.")
+	runsAsRootDesc = newDescSource(
+		"container_runs_as_root",
+		"Whether the container runs as root.")
+	commandInfoDesc = newDescSource(
+		"container_command_info",
+		"Entrypoint and command of the container, truncated. The value is always 1.")
+	envSensitiveVarsDesc = newDescSource(
+		"container_env_sensitive_vars",
+		"Number of environment variables of the container whose name looks like a secret.")
+	imageInfoDesc = newDescSource(
+		"container_image_info",
+		"Image the container runs, by ID and registry digest. The value is always 1.")
+	imageCreatedDesc = newDescSource(
+		"container_image_created_timestamp_seconds",
+		"Time when the image of the container was built.")
+	imageSizeDesc = newDescSource(
+		"container_image_size_bytes",
+		"Size of the image of the container, including its parent layers.")
+	mountsDesc = newDescSource(
+		"container_mounts",
+		"Number of mounts of the container, by type.")
+	mountInfoDesc = newDescSource(
+		"container_mount_info",
+		"Mount of the container. The value is always 1.")
+	portPublishedInfoDesc = newDescSource(
+		"container_port_published_info",
+		"Port of the container published on the host. The value is always 1.")
+	networksDesc = newDescSource(
+		"container_networks",
+		"Number of networks the container is attached to.")
+	networkInfoDesc = newDescSource(
+		"container_network_info",
+		"Network the container is attached to, with its addresses on it. The value is always 1.")
+	privilegedDesc = newDescSource(
+		"container_privileged",
+		"Whether the container runs in privileged mode, with all capabilities and access to the devices of the host.")
+	readonlyRootfsDesc = newDescSource(
+		"container_readonly_rootfs",
+		"Whether the root filesystem of the container is mounted read-only.")
+	capAddInfoDesc = newDescSource(
+		"container_cap_add_info",
+		"Linux capability added to the container. The value is always 1.")
+	securityOptInfoDesc = newDescSource(
+		"container_security_opt_info",
+		"Security option of the container, such as its seccomp profile or its AppArmor or SELinux settings. The value is always 1.")
+	memoryLimitDesc = newDescSource(
+		"container_spec_memory_limit_bytes",
+		"Memory limit of the container, 0 if unlimited.")
+	memorySwapLimitDesc = newDescSource(
+		"container_spec_memory_swap_limit_bytes",
+		"Limit of memory and swap of the container, 0 if unset and -1 if swap is unlimited.")
+	memoryReservationDesc = newDescSource(
+		"container_spec_memory_reservation_bytes",
+		"Memory soft limit of the container, 0 if unset.")
+	cpuQuotaDesc = newDescSource(
+		"container_spec_cpu_quota",
+		"CPU time in microseconds the container may use per container_spec_cpu_period, 0 if unlimited.")
+	cpuPeriodDesc = newDescSource(
+		"container_spec_cpu_period",
+		"Period in microseconds of the CPU quota of the container.")
+	cpuSharesDesc = newDescSource(
+		"container_spec_cpu_shares",
+		"Relative CPU weight of the container.")
+	cpusetInfoDesc = newDescSource(
+		"container_spec_cpuset_info",
+		"CPUs and memory nodes the container may use, empty for all. The value is always 1.")
+	ulimitDesc = newDescSource(
+		"container_spec_ulimit",
+		"Ulimit configured for the container, -1 if unlimited.")
+	logDriverInfoDesc = newDescSource(
+		"container_log_driver_info",
+		"Log driver of the container and its rotation options. The value is always 1.")
+	devicesDesc = newDescSource(
+		"container_devices",
+		"Number of devices of the host passed through to the container.")
+	deviceInfoDesc = newDescSource(
+		"container_device_info",
+		"Device of the host passed through to the container. The value is always 1.")
+	restartPolicyInfoDesc = newDescSource(
+		"container_restart_policy_info",
+		"Restart policy of the container. The value is always 1.")
+	infoDesc = newDescSource(
+		"container_info",
+		"Information about the container. The value is always 1.")
+	dataStaleDesc = newDescSource(
+		"docker_exporter_data_stale_seconds",
+		"Seconds since the container data was last refreshed successfully.")
+	severityDesc = newDescSource(
+		"container_severity",
+		"Severity of the container state according to the configured rules: 0=ok, 1=warning, 2=critical.")
+	lastCollectSuccessDesc = newDescSource(
+		"docker_exporter_last_collect_success_timestamp_seconds",
+		"Time when the container data was last refreshed successfully.")
+	lastCollectDurationDesc = newDescSource(
+		"docker_exporter_last_collect_duration_seconds",
+		"Duration of the last refresh of the container data.")
+	daemonUpDesc = newDescSource(
+		"docker_exporter_daemon_up",
+		"Whether the docker daemon could be reached on the last refresh.")
+	capabilityDesc = newDescSource(
+		"docker_capability",
+		"Whether the docker daemon supports the capability.")
+	phaseDurationDesc = newDescSource(
+		"docker_exporter_phase_duration_seconds",
+		"Duration of the phases of the last collection, to tell slowness of the daemon from slowness of the exporter.")
+	snapshotRestoredDesc = newDescSource(
+		"docker_exporter_snapshot_restored",
+		"Whether the container data is still the snapshot restored at startup, see -collector.snapshot-file.")
+	circuitOpenDesc = newDescSource(
+		"docker_exporter_circuit_open",
+		"Whether calls to the docker daemon are suspended after consecutive failures.")
+)
+
+// The index of a status is its value with -metrics.state-encoding=enum, new
+// statuses go at the end.
+var (
+	healthStatuses    = []string{"none", "starting", "healthy", "unhealthy"}
+	containerStatuses = []string{"paused", "restarting", "running", "removing", "dead", "created", "exited"}
+)
+
+// labelNameRegexp matches the characters replaced in label names.
+var labelNameRegexp = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// labelNames memoizes the label names derived from container labels.
+var labelNames = newLabelNameCache(labelNameCacheSize)
+
+// labelValues deduplicates label values shared by several containers.
+var labelValues = newInterner(labelValuesInternSize)
+
+// collectPhases are the phases of a collection: listing the containers,
+// inspecting them, and emitting their metrics.
+var collectPhases = [...]string{"list", "inspect", "emit"}
+
+const (
+	phaseList = iota
+	phaseInspect
+	phaseEmit
 )
 
+var errDaemonUnreachable = errors.New("docker daemon unreachable")
+
+// errPanicked is the error of a refresh, an inspection or a conversion that
+// panicked. The panic itself is logged by recoverPanic.
+var errPanicked = errors.New("recovered from a panic")
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sanitizeLabelValue replaces invalid UTF-8 and control characters such as
+// newlines, which would break the exposition. It reports whether v was
+// already valid.
+func sanitizeLabelValue(v string) (string, bool) {
+	valid := utf8.ValidString(v) && strings.IndexFunc(v, unicode.IsControl) < 0
+	if valid {
+		return v, true
+	}
+	v = strings.ToValidUTF8(v, string(utf8.RuneError))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, v), false
+}
+
+// anonymizeValue replaces v by a short hash, so outputs can be shared without
+// leaking names while equal values stay equal.
+func anonymizeValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:6])
+}
+
+// managedBy classifies the system owning a container from its labels.
+func managedBy(labels map[string]string) string {
+	switch {
+	case labels["io.kubernetes.pod.name"] != "":
+		return "kubernetes"
+	case labels["com.hashicorp.nomad.alloc_id"] != "":
+		return "nomad"
+	case labels["com.docker.swarm.task.id"] != "", labels["com.docker.swarm.service.id"] != "":
+		return "swarm"
+	case labels["com.docker.compose.project"] != "":
+		return "compose"
+	default:
+		return "plain"
+	}
+}
+
 func (c *dockerHealthCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- healthStatusDesc.Desc(nil)
-	ch <- statusDesc.Desc(nil)
+	if c.stateEnum {
+		ch <- healthStatusCodeDesc.Desc(nil)
+		ch <- statusCodeDesc.Desc(nil)
+		ch <- healthStatusCodeInfoDesc.Desc(nil)
+		ch <- statusCodeInfoDesc.Desc(nil)
+	} else {
+		ch <- healthStatusDesc.Desc(nil)
+		ch <- statusDesc.Desc(nil)
+	}
+	ch <- healthcheckConfiguredDesc.Desc(nil)
+	ch <- healthcheckIntervalDesc.Desc(nil)
+	ch <- healthcheckTimeoutDesc.Desc(nil)
+	ch <- healthcheckRetriesDesc.Desc(nil)
+	ch <- healthcheckStartPeriodDesc.Desc(nil)
+	ch <- healthFailingStreakDesc.Desc(nil)
+	ch <- healthLastExitCodeDesc.Desc(nil)
+	ch <- healthLastCheckDurationDesc.Desc(nil)
+	ch <- healthLastCheckTimestampDesc.Desc(nil)
+	if c.healthOutputInfo {
+		ch <- healthLastOutputInfoDesc.Desc(nil)
+	}
 	ch <- oomkilledDesc.Desc(nil)
+	ch <- pidDesc.Desc(nil)
+	ch <- pidMissingDesc.Desc(nil)
+	if c.processes {
+		ch <- processesDesc.Desc(nil)
+	}
+	ch <- logFileSizeDesc.Desc(nil)
+	if c.sizes {
+		ch <- fsRwDesc.Desc(nil)
+		ch <- fsRootfsDesc.Desc(nil)
+	}
+	ch <- createdatDesc.Desc(nil)
 	ch <- startedatDesc.Desc(nil)
+	ch <- uptimeDesc.Desc(nil)
 	ch <- finishedatDesc.Desc(nil)
+	ch <- exitcodeDesc.Desc(nil)
+	ch <- errorInfoDesc.Desc(nil)
 	ch <- restartcountDesc.Desc(nil)
+	ch <- restartsTotalDesc.Desc(nil)
+	ch <- startLatencyDesc.Desc(nil)
+	ch <- timeToHealthyDesc.Desc(nil)
+	ch <- stateDurationDesc.Desc(nil)
+	ch <- pausedDesc.Desc(nil)
+	ch <- stuckRemovingDesc.Desc(nil)
+	if c.events {
+		ch <- restartCausesDesc.Desc(nil)
+		ch <- restartsRecentDesc.Desc(nil)
+		ch <- oomKillsDesc.Desc(nil)
+		ch <- eventsTotalDesc.Desc(nil)
+		ch <- imageEventsTotalDesc.Desc(nil)
+		ch <- exitsTotalDesc.Desc(nil)
+		ch <- healthTransitionsDesc.Desc(nil)
+	}
+	ch <- infoDesc.Desc(nil)
+	ch <- restartPolicyInfoDesc.Desc(nil)
+	ch <- privilegedDesc.Desc(nil)
+	ch <- readonlyRootfsDesc.Desc(nil)
+	ch <- capAddInfoDesc.Desc(nil)
+	ch <- securityOptInfoDesc.Desc(nil)
+	ch <- memoryLimitDesc.Desc(nil)
+	ch <- memorySwapLimitDesc.Desc(nil)
+	ch <- memoryReservationDesc.Desc(nil)
+	ch <- cpuQuotaDesc.Desc(nil)
+	ch <- cpuPeriodDesc.Desc(nil)
+	ch <- cpuSharesDesc.Desc(nil)
+	ch <- cpusetInfoDesc.Desc(nil)
+	ch <- ulimitDesc.Desc(nil)
+	ch <- logDriverInfoDesc.Desc(nil)
+	ch <- devicesDesc.Desc(nil)
+	ch <- deviceInfoDesc.Desc(nil)
+	ch <- mountsDesc.Desc(nil)
+	ch <- portPublishedInfoDesc.Desc(nil)
+	ch <- networksDesc.Desc(nil)
+	ch <- networkInfoDesc.Desc(nil)
+	if c.mountInfo {
+		ch <- mountInfoDesc.Desc(nil)
+	}
+	ch <- imageInfoDesc.Desc(nil)
+	ch <- imageCreatedDesc.Desc(nil)
+	ch <- imageSizeDesc.Desc(nil)
+	if c.exportUptime {
+		ch <- uptimeHistogramDesc.Desc(nil)
+	}
+	ch <- userInfoDesc.Desc(nil)
+	ch <- runsAsRootDesc.Desc(nil)
+	if c.commandInfo {
+		ch <- commandInfoDesc.Desc(nil)
+	}
+	if len(c.sensitiveEnv) > 0 {
+		ch <- envSensitiveVarsDesc.Desc(nil)
+	}
+	ch <- dataStaleDesc.Desc(nil)
+	ch <- lastCollectSuccessDesc.Desc(nil)
+	ch <- lastCollectDurationDesc.Desc(nil)
+	ch <- daemonUpDesc.Desc(nil)
+	ch <- circuitOpenDesc.Desc(nil)
+	if c.snapshotFile != "" {
+		ch <- snapshotRestoredDesc.Desc(nil)
+	}
+	ch <- capabilityDesc.Desc(nil)
+	ch <- phaseDurationDesc.Desc(nil)
+	for _, dm := range c.derivedMetrics {
+		ch <- dm.desc.Desc(nil)
+	}
+	if len(c.severityRules) > 0 {
+		ch <- severityDesc.Desc(nil)
+	}
+}
+
+func (c *dockerHealthCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if c.streaming {
+		return c.stream(ctx, ch)
+	}
+
+	var errs []error
+	if c.pollInterval == 0 || freshRequested(ctx) {
+		if err := c.refresh(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to collect containers: %w", err))
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.pollInterval > 0 && c.refreshErr != nil {
+		errs = append(errs, fmt.Errorf("failed to collect containers: %w", c.refreshErr))
+	}
+	begin := time.Now()
+	if err := c.collectMetrics(ch); err != nil {
+		errs = append(errs, fmt.Errorf("failed to collect metrics: %w", err))
+	}
+	if c.stateEnum {
+		collectStatusCodes(ch)
+	}
+	c.phaseDurations[phaseEmit].Store(int64(time.Since(begin)))
+	c.collectSelfMetrics(ch)
+	return errors.Join(errs...)
+}
+
+// collectSelfMetrics sends the metrics about the collection itself. c.mu must
+// be held.
+func (c *dockerHealthCollector) collectSelfMetrics(ch chan<- prometheus.Metric) {
+	if !c.lastseen.IsZero() {
+		for i, phase := range collectPhases {
+			ch <- prometheus.MustNewConstMetric(phaseDurationDesc.Desc(map[string]string{"phase": phase}), prometheus.GaugeValue, time.Duration(c.phaseDurations[i].Load()).Seconds())
+		}
+	}
+	if !c.lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(dataStaleDesc.Desc(nil), prometheus.GaugeValue, time.Since(c.lastSuccess).Seconds())
+		ch <- prometheus.MustNewConstMetric(lastCollectSuccessDesc.Desc(nil), prometheus.GaugeValue, float64(c.lastSuccess.UnixNano())/1e9)
+	}
+	if !c.lastseen.IsZero() {
+		ch <- prometheus.MustNewConstMetric(lastCollectDurationDesc.Desc(nil), prometheus.GaugeValue, c.lastDuration.Seconds())
+	}
+	ch <- prometheus.MustNewConstMetric(daemonUpDesc.Desc(nil), prometheus.GaugeValue, b2f(c.outageSince.IsZero()))
+	ch <- prometheus.MustNewConstMetric(circuitOpenDesc.Desc(nil), prometheus.GaugeValue, b2f(c.breaker.isOpen()))
+	if c.snapshotFile != "" {
+		ch <- prometheus.MustNewConstMetric(snapshotRestoredDesc.Desc(nil), prometheus.GaugeValue, b2f(c.restored))
+	}
+	if caps := c.capabilities.Load(); caps != nil {
+		for name, supported := range caps.byName() {
+			ch <- prometheus.MustNewConstMetric(capabilityDesc.Desc(map[string]string{"name": name}), prometheus.GaugeValue, b2f(supported))
+		}
+	}
+}
+
+// refreshCall is a cache refresh in progress.
+type refreshCall struct {
+	done chan struct{}
+	err  error
 }
 
-func (c *dockerHealthCollector) Collect(ch chan<- prometheus.Metric) {
+// refresh updates the cache once it is older than maxCacheAge, or when the
+// scrape asked for fresh data. Concurrent scrapes share a single refresh
+// instead of each loading the daemon. The refresh is not cancelled with the
+// scrape that started it, so the others still get its result; it is bounded
+// by refreshTimeout instead. A scrape only stops waiting for it when its own
+// ctx is done.
+func (c *dockerHealthCollector) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	if time.Since(c.lastseen) < c.maxCacheAge() && !freshRequested(ctx) {
+		c.mu.Unlock()
+		return nil
+	}
+	call := c.inflight
+	if call == nil {
+		if now := time.Now(); !c.breaker.allow(now) {
+			c.trackOutage(now, true)
+			c.refreshErr = errCircuitOpen
+			c.generation.Add(1)
+			c.mu.Unlock()
+			return errCircuitOpen
+		}
+		call = &refreshCall{done: make(chan struct{})}
+		c.inflight = call
+		previous := c.cachedContainers()
+		refreshCtx, cancel := context.WithTimeout(context.Background(), c.refreshTimeout)
+		go func() {
+			defer cancel()
+			defer close(call.done)
+			defer func() {
+				// A refresh that panicked did not clear c.inflight, so no
+				// other refresh could start.
+				if call.err == errPanicked {
+					c.mu.Lock()
+					if c.inflight == call {
+						c.inflight = nil
+					}
+					c.mu.Unlock()
+				}
+			}()
+			defer recoverPanic("refresh")
+			call.err = errPanicked
+			call.err = c.runRefresh(refreshCtx, previous)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runRefresh collects the containers and replaces the cache with them. Only
+// refresh calls it, with c.inflight set.
+func (c *dockerHealthCollector) runRefresh(ctx context.Context, previous []containerState) error {
+	now := time.Now()
+	c.daemonVisible.Store(findProcess(c.procfs, "dockerd") != 0)
+	cache, err := c.collectContainer(ctx, previous)
+
+	if err != nil {
+		c.lastError.Store(&collectError{err.Error(), now})
+	}
+
+	c.mu.Lock()
+	if cache != nil {
+		c.setCache(cache, now)
+		if !c.synced.Load() {
+			c.synced.Store(true)
+			normalLogger.Log("message", "Initial sync complete", "containers", len(cache), "duration", time.Since(now).String())
+		}
+	}
+	if cache == nil || err != nil || c.refreshErr != nil {
+		// The error metrics change even when the containers do not.
+		c.generation.Add(1)
+	}
+	var save []containerState
+	if err == nil {
+		c.lastSuccess = now
+		c.restored = false
+		if c.snapshotFile != "" && now.Sub(c.snapshotSaved) >= snapshotInterval {
+			c.snapshotSaved = now
+			save = c.cachedContainers()
+		}
+	}
+	c.trackOutage(now, errors.Is(err, errDaemonUnreachable))
+	c.breaker.record(now, errors.Is(err, errDaemonUnreachable))
+	c.lastseen = now
+	c.lastDuration = time.Since(now)
+	c.refreshErr = err
+	c.inflight = nil
+	dirty := c.dirty
+	c.dirty = map[string]bool{}
+	c.mu.Unlock()
+
+	if save != nil {
+		c.saveSnapshot(save, now)
+	}
+
+	// Containers changed by events during the refresh may have been
+	// overwritten with older data.
+	for id := range dirty {
+		c.reinspect(ctx, id)
+	}
+	return err
+}
+
+// maxCacheAge returns how long the cache is used before a full refresh. When
+// events keep the cache up to date, it only needs an occasional resync. The
+// background poller refreshes it on its own schedule.
+func (c *dockerHealthCollector) maxCacheAge() time.Duration {
+	if c.pollInterval > 0 {
+		return 0
+	}
+	if c.resyncInterval > 0 {
+		return c.resyncInterval
+	}
+	return c.cachePeriod
+}
+
+// setCache replaces the cache with a snapshot taken at now. c.mu must be
+// held.
+func (c *dockerHealthCollector) setCache(cache []containerState, now time.Time) {
+	previous := c.cachedContainers()
+	changed := !sameStates(previous, cache)
+	if c.removedTTL > 0 && c.trackRemoved(previous, cache, now) {
+		changed = true
+	}
+	c.containerInfoCache, c.compressed = cache, nil
+	for i := range cache {
+		if cache[i].metricLabels.names == nil {
+			cache[i].metricLabels = c.metricLabels(&cache[i])
+		}
+	}
+	// Compressing thousands of containers takes a while. Events patch the
+	// cache one container at a time, so it is compressed once they settle
+	// rather than every time.
+	if c.compress && !c.compressScheduled && len(cache) > 0 {
+		c.compressScheduled = true
+		time.AfterFunc(compressDelay, c.compressDecoded)
+	}
+	c.cacheSize.Store(int64(len(cache)))
+	c.statuses.observe(cache, now)
+	c.warmups.observe(cache)
+	c.starts.observe(cache)
+	c.restartTotals.observe(cache, now)
+	if changed {
+		c.generation.Add(1)
+	}
+}
+
+// sameStates tells whether two caches hold the same container states, in the
+// same order, regardless of their metric labels.
+func sameStates(a, b []containerState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		x.metricLabels, y.metricLabels = labelSet{}, labelSet{}
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressDelay is how long a new cache stays decoded with
+// -collector.compress-cache.
+const compressDelay = 5 * time.Second
+
+// compressDecoded compresses the decoded cache. It runs without c.mu held, so
+// neither scrapes nor events wait for it, and leaves the cache decoded if it
+// was replaced meanwhile, as setCache then compresses the new one.
+func (c *dockerHealthCollector) compressDecoded() {
+	defer recoverPanic("compressing the cache")
+	c.mu.Lock()
+	c.compressScheduled = false
+	cache := c.containerInfoCache
+	c.mu.Unlock()
+	if len(cache) == 0 {
+		return
+	}
+	compressed, err := compressCache(cache)
+	if err != nil {
+		warnLogger.Log("message", fmt.Sprintf("Failed to compress the cache, keeping it decoded: %v", err))
+		return
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if len(c.containerInfoCache) == len(cache) && &c.containerInfoCache[0] == &cache[0] {
+		c.containerInfoCache, c.compressed = nil, compressed
+	}
+}
+
+// cachedContainers returns the cache, decoded with -collector.compress-cache.
+// c.mu must be held.
+func (c *dockerHealthCollector) cachedContainers() []containerState {
+	if c.compressed == nil {
+		return c.containerInfoCache
+	}
+	cache, err := c.compressed.containers()
+	if err != nil {
+		warnLogger.Log("message", fmt.Sprintf("Failed to decode the cache: %v", err))
+	}
+	return cache
+}
+
+// removedContainer is the last known state of a removed container.
+type removedContainer struct {
+	state containerState
+	at    time.Time
+}
+
+// trackRemoved records the containers of the previous cache missing from
+// the new cache, so their last known state keeps being exported for
+// removedTTL. Short lived containers then give alert rules a final sample. It
+// reports whether some of them expired. c.mu must be held.
+func (c *dockerHealthCollector) trackRemoved(previous, cache []containerState, now time.Time) (expired bool) {
+	present := make(map[string]bool, len(cache))
+	for _, info := range cache {
+		present[info.ID] = true
+		delete(c.removed, info.ID)
+	}
+	for _, info := range previous {
+		if !present[info.ID] {
+			c.removed[info.ID] = removedContainer{removedState(info, now), now}
+		}
+	}
+	for id, r := range c.removed {
+		if now.Sub(r.at) >= c.removedTTL {
+			delete(c.removed, id)
+			expired = true
+		}
+	}
+	return expired
+}
+
+// removedState returns the last known state of a container removed at now.
+// A removed container does not run anymore, so one last seen running,
+// paused, restarting or removing is exported as exited at now, with the exit
+// code it was last seen with.
+func removedState(info containerState, now time.Time) containerState {
+	switch info.Status {
+	case "running", "paused", "restarting", "removing":
+		info.Status = "exited"
+		info.Pid = 0
+		info.FinishedAt = now.UTC().Format(time.RFC3339Nano)
+	}
+	return info
+}
+
+// circuitOpen reports whether the circuit breaker suspends the calls to the
+// docker daemon.
+func (c *dockerHealthCollector) circuitOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.breaker.isOpen()
+}
+
+// trackOutage records transitions between a reachable and an unreachable
+// daemon. During an outage, for example a dockerd restart with live-restore,
+// the cached state keeps being exported until the grace period is over.
+func (c *dockerHealthCollector) trackOutage(now time.Time, unreachable bool) {
+	switch {
+	case unreachable && c.outageSince.IsZero():
+		c.outageSince = now
+		daemonOutagesTotal.Inc()
+		warnLogger.Log("message", "Docker daemon is unreachable, serving the last known state")
+	case unreachable && c.outageGracePeriod > 0 && now.Sub(c.outageSince) > c.outageGracePeriod && (c.containerInfoCache != nil || c.compressed != nil):
+		c.containerInfoCache, c.compressed = nil, nil
+		warnLogger.Log("message", fmt.Sprintf("Docker daemon has been unreachable for more than %v, dropping the last known state", c.outageGracePeriod))
+	case !unreachable && !c.outageSince.IsZero():
+		normalLogger.Log("message", fmt.Sprintf("Docker daemon is reachable again after %v", now.Sub(c.outageSince)))
+		c.outageSince = time.Time{}
+		// The daemon may have been upgraded or reconfigured.
+		go c.probeCapabilities(context.Background())
+	}
+}
+
+func (c *dockerHealthCollector) collectMetrics(ch chan<- prometheus.Metric) error {
+	var errs []error
 	now := time.Now()
-	if now.Sub(c.lastseen) >= cachePeriod {
-		c.collectContainer()
-		c.lastseen = now
+	var uptime *uptimeHistogram
+	if c.exportUptime && !c.fast {
+		uptime = newUptimeHistogram(now)
+	}
+	collect := func(info containerState) {
+		if err := c.collectContainerMetrics(ch, info); err != nil {
+			errs = append(errs, fmt.Errorf("container %s: %w", info.ID, err))
+		}
+		// The fast mode does not know the restart counts.
+		if !c.fast {
+			if err := c.collectRestartsTotal(ch, &info); err != nil {
+				errs = append(errs, fmt.Errorf("container %s: %w", info.ID, err))
+			}
+		}
+		if uptime != nil {
+			uptime.observe(&info)
+		}
+	}
+	if c.compressed != nil {
+		err := c.compressed.each(collect)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	} else {
+		for _, info := range c.containerInfoCache {
+			collect(info)
+		}
 	}
-	c.collectMetrics(ch)
+	if uptime != nil {
+		ch <- uptime.metric()
+	}
+	if c.events {
+		c.collectImageEvents(ch)
+	}
+	for _, r := range c.removed {
+		if now.Sub(r.at) >= c.removedTTL {
+			continue
+		}
+		if err := c.collectContainerMetrics(ch, r.state); err != nil {
+			errs = append(errs, fmt.Errorf("removed container %s: %w", r.state.ID, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (c *dockerHealthCollector) collectMetrics(ch chan<- prometheus.Metric) {
-	for _, info := range c.containerInfoCache {
-		var labels = map[string]string{}
+// metricLabels builds the labels shared by all the metrics of a container.
+// Label values are anonymized with -anonymize, and sanitized.
+func (c *dockerHealthCollector) metricLabels(info *containerState) labelSet {
+	var labels = map[string]string{}
 
-		rep := regexp.MustCompile("[^a-zA-Z0-9_]")
+	for k, v := range info.Labels {
+		labels[labelNames.sanitize("container_label_"+k)] = v
+		if c.routingLabelPrefix != "" && strings.HasPrefix(k, c.routingLabelPrefix) && len(k) > len(c.routingLabelPrefix) {
+			// Routing labels get a stable name for Alertmanager routing trees.
+			labels[labelNames.sanitize("alert_"+strings.TrimPrefix(k, c.routingLabelPrefix))] = v
+		}
+	}
+	labels["id"] = "/docker/" + info.ID
+	labels["image"] = info.Image
+	labels["name"] = info.Name
+	if c.anonymize {
+		for k, v := range labels {
+			if k != "id" {
+				labels[k] = anonymizeValue(v)
+			}
+		}
+	}
+	for k, v := range labels {
+		if sanitized, ok := sanitizeLabelValue(v); !ok {
+			labels[k] = sanitized
+			labelValuesSanitizedTotal.Inc()
+			labelWarningLogger.Log(info.ID, "message", "Sanitized invalid label value", "container", labels["name"], "label", k)
+		}
+	}
 
-		for k, v := range info.Config.Labels {
-			label := strings.ToLower("container_label_" + k)
-			labels[rep.ReplaceAllLiteralString(label, "_")] = v
+	for k, v := range labels {
+		labels[k] = labelValues.intern(v)
+	}
+	return newLabelSet(labels)
+}
+
+func (c *dockerHealthCollector) collectContainerMetrics(ch chan<- prometheus.Metric, info containerState) error {
+	// A malformed container must not prevent the others from being exported.
+	defer recoverPanic("container " + info.ID)
+
+	ls := info.metricLabels
+	if ls.names == nil {
+		ls = c.metricLabels(&info)
+	}
+
+	var errs []error
+	emit := func(desc *descSource, ls labelSet, valueType prometheus.ValueType, value float64) {
+		m, err := desc.metric(valueType, value, ls)
+		if err != nil {
+			errs = append(errs, err)
+			return
 		}
-		labels["id"] = "/docker/" + info.ID
-		labels["image"] = info.Config.Image
-		labels["name"] = strings.TrimPrefix(info.Name, "/")
+		ch <- m
+	}
+	send := func(desc *descSource, ls labelSet, value float64) {
+		emit(desc, ls, prometheus.GaugeValue, value)
+	}
+	count := func(desc *descSource, ls labelSet, value float64) {
+		emit(desc, ls, prometheus.CounterValue, value)
+	}
 
-		b2f := func(b bool) float64 {
-			if b {
-				return 1
+	if c.stateEnum {
+		send(&healthStatusCodeDesc, ls, statusCode(healthStatuses, info.Health))
+		send(&statusCodeDesc, ls, statusCode(containerStatuses, info.Status))
+	} else {
+		for _, lv := range healthStatuses {
+			send(&healthStatusDesc, ls.with("status", lv), b2f(info.Health == lv))
+		}
+		for _, lv := range containerStatuses {
+			send(&statusDesc, ls.with("status", lv), b2f(info.Status == lv))
+		}
+	}
+	if hc := info.Healthcheck; hc != nil {
+		send(&healthcheckConfiguredDesc, ls, b2f(hc.Configured))
+		if hc.Configured {
+			send(&healthcheckIntervalDesc, ls, hc.Interval.Seconds())
+			send(&healthcheckTimeoutDesc, ls, hc.Timeout.Seconds())
+			send(&healthcheckRetriesDesc, ls, float64(hc.Retries))
+			send(&healthcheckStartPeriodDesc, ls, hc.StartPeriod.Seconds())
+		}
+	}
+	// The fast mode does not know the failing streak nor when healthchecks
+	// ran. The metrics of the fields it does not know are left out rather
+	// than exported as 0, see containerFromSummary.
+	if !c.fast {
+		send(&healthFailingStreakDesc, ls, float64(info.FailingStreak))
+	}
+	if info.Health != "none" && !c.fast {
+		var checkedAt float64
+		if !info.HealthCheckedAt.IsZero() {
+			checkedAt = float64(info.HealthCheckedAt.UnixNano()) / 1e9
+		}
+		send(&healthLastCheckTimestampDesc, ls, checkedAt)
+	}
+	if !info.HealthCheckedAt.IsZero() {
+		send(&healthLastExitCodeDesc, ls, float64(info.HealthExitCode))
+		send(&healthLastCheckDurationDesc, ls, info.HealthCheckDuration.Seconds())
+		if c.healthOutputInfo {
+			output := truncateOutput(info.HealthOutput, maxHealthOutputLabel)
+			if c.anonymize {
+				output = anonymizeValue(output)
+			}
+			output, _ = sanitizeLabelValue(output)
+			send(&healthLastOutputInfoDesc, ls.with("output", output), 1)
+		}
+	}
+	if !c.fast {
+		send(&oomkilledDesc, ls, b2f(info.OOMKilled))
+		send(&pidDesc, ls, float64(info.Pid))
+	}
+	if c.processes && info.Processes > 0 {
+		send(&processesDesc, ls, float64(info.Processes))
+	}
+	if info.SizeRw != nil {
+		send(&fsRwDesc, ls, float64(*info.SizeRw))
+	}
+	if info.SizeRootFs != nil {
+		send(&fsRootfsDesc, ls, float64(*info.SizeRootFs))
+	}
+	if info.LogFile != "" {
+		if size, ok := logFileSize(c.rootfs, info.LogFile); ok {
+			send(&logFileSizeDesc, ls, float64(size))
+		}
+	}
+	if info.Status == "running" && info.Pid > 0 {
+		if missing, known := pidMissing(c.procfs, info.Pid, c.daemonVisible.Load()); known {
+			send(&pidMissingDesc, ls, b2f(missing))
+		}
+	}
+	if createdat, err := parseTimestamp(info.Created); err != nil {
+		errs = append(errs, err)
+	} else {
+		send(&createdatDesc, ls, createdat)
+	}
+	if !c.fast {
+		if startedat, err := parseTimestamp(info.StartedAt); err != nil {
+			errs = append(errs, err)
+		} else {
+			send(&startedatDesc, ls, startedat)
+		}
+		uptime, _ := uptimeSeconds(&info, time.Now())
+		send(&uptimeDesc, ls, uptime)
+		if finishedat, err := parseTimestamp(info.FinishedAt); err != nil {
+			errs = append(errs, err)
+		} else {
+			send(&finishedatDesc, ls, finishedat)
+		}
+	}
+	send(&exitcodeDesc, ls, float64(info.ExitCode))
+	if info.Error != "" {
+		containerError := truncateOutput(info.Error, maxErrorLabel)
+		if c.anonymize {
+			containerError = anonymizeValue(containerError)
+		}
+		containerError, _ = sanitizeLabelValue(containerError)
+		send(&errorInfoDesc, ls.with("error", containerError), 1)
+	}
+	if !c.fast {
+		send(&restartcountDesc, ls, float64(info.RestartCount))
+	}
+	if seconds, ok := c.starts.latency(info.ID); ok {
+		send(&startLatencyDesc, ls, seconds)
+	}
+	if seconds, ok := c.warmups.timeToHealthy(info.ID); ok {
+		send(&timeToHealthyDesc, ls, seconds)
+	}
+	inStatus := c.statuses.duration(info.ID, time.Now()).Seconds()
+	send(&stateDurationDesc, ls.with("status", info.Status), inStatus)
+	var paused, stuck float64
+	switch info.Status {
+	case "paused":
+		paused = inStatus
+	case "removing", "dead":
+		stuck = inStatus
+	}
+	send(&pausedDesc, ls, paused)
+	send(&stuckRemovingDesc, ls, stuck)
+	if c.events {
+		key := c.counterKey(info.ID, info.Name)
+		for _, cause := range restartCauses {
+			count(&restartCausesDesc, ls.with("cause", cause), c.restarts.count(key, cause))
+		}
+		count(&oomKillsDesc, ls, c.restarts.ooms(key))
+		for _, action := range countedEvents {
+			count(&eventsTotalDesc, ls.with("action", action), c.eventCounts.count(key, action))
+		}
+		for code, n := range c.eventCounts.exitCodes(key) {
+			count(&exitsTotalDesc, ls.with("exit_code", code), n)
+		}
+		send(&restartsRecentDesc, ls.with("window", model.Duration(c.restarts.window).String()), c.restarts.recentCount(key, time.Now()))
+		for t, n := range c.health.transitions(key) {
+			count(&healthTransitionsDesc, ls.with("from", t.from).with("to", t.to), n)
+		}
+	}
+	send(&infoDesc, ls.with("managed_by", managedBy(info.Labels)), 1)
+	if info.ImageID != "" {
+		send(&imageInfoDesc, ls.with("image_id", info.ImageID).with("digest", info.ImageDetails.Digest), 1)
+	}
+	if created, err := parseTimestamp(info.ImageDetails.Created); err == nil && created > 0 {
+		send(&imageCreatedDesc, ls, created)
+	}
+	if info.ImageDetails.Size > 0 {
+		send(&imageSizeDesc, ls, float64(info.ImageDetails.Size))
+	}
+	if info.RestartPolicy != "" {
+		send(&restartPolicyInfoDesc, ls.with("policy", info.RestartPolicy).with("max_retries", strconv.Itoa(info.RestartMaxRetries)), 1)
+	}
+	if host := info.Host; host != nil {
+		send(&privilegedDesc, ls, b2f(host.Privileged))
+		send(&readonlyRootfsDesc, ls, b2f(host.ReadonlyRootfs))
+		for _, capability := range host.CapAdd {
+			send(&capAddInfoDesc, ls.with("capability", capability), 1)
+		}
+		for _, opt := range host.SecurityOpt {
+			// Values such as profile paths are local to the host.
+			value := opt.Value
+			if c.anonymize {
+				value = anonymizeValue(value)
+			}
+			value, _ = sanitizeLabelValue(value)
+			send(&securityOptInfoDesc, ls.with("option", opt.Option).with("value", value), 1)
+		}
+		send(&memoryLimitDesc, ls, float64(host.Memory))
+		send(&memorySwapLimitDesc, ls, float64(host.MemorySwap))
+		send(&memoryReservationDesc, ls, float64(host.MemoryReservation))
+		send(&cpuQuotaDesc, ls, float64(host.CPUQuota))
+		send(&cpuPeriodDesc, ls, float64(host.CPUPeriod))
+		send(&cpuSharesDesc, ls, float64(host.CPUShares))
+		send(&cpusetInfoDesc, ls.with("cpus", host.CpusetCpus).with("mems", host.CpusetMems), 1)
+		for _, u := range host.Ulimits {
+			send(&ulimitDesc, ls.with("ulimit", u.Name).with("type", "soft"), float64(u.Soft))
+			send(&ulimitDesc, ls.with("ulimit", u.Name).with("type", "hard"), float64(u.Hard))
+		}
+		send(&logDriverInfoDesc, ls.with("driver", host.LogDriver).with("max_size", host.LogMaxSize).with("max_file", host.LogMaxFile), 1)
+		send(&devicesDesc, ls, float64(len(host.Devices)))
+		for _, d := range host.Devices {
+			send(&deviceInfoDesc, ls.with("path_on_host", d.PathOnHost).with("path_in_container", d.PathInContainer).with("permissions", d.Permissions), 1)
+		}
+	}
+	if info.Mounts != nil {
+		for _, t := range mountTypes {
+			var n float64
+			for _, m := range info.Mounts {
+				if m.Type == t {
+					n++
+				}
 			}
-			return 0
+			send(&mountsDesc, ls.with("type", t), n)
 		}
-		mapcopy := func(src map[string]string) prometheus.Labels {
-			dst := map[string]string{}
-			for k, v := range labels {
-				dst[k] = v
+		if c.mountInfo {
+			for _, m := range info.Mounts {
+				source, destination := m.Source, m.Destination
+				if c.anonymize {
+					source, destination = anonymizeValue(source), anonymizeValue(destination)
+				}
+				source, _ = sanitizeLabelValue(source)
+				destination, _ = sanitizeLabelValue(destination)
+				send(&mountInfoDesc, ls.with("type", m.Type).with("source", source).with("destination", destination).with("rw", strconv.FormatBool(m.RW)), 1)
 			}
-			return dst
 		}
+	}
+	for _, p := range info.Ports {
+		send(&portPublishedInfoDesc, ls.with("container_port", p.ContainerPort).with("host_port", p.HostPort).with("protocol", p.Protocol).with("host_ip", p.HostIP), 1)
+	}
+	if info.Networks != nil {
+		send(&networksDesc, ls, float64(len(info.Networks)))
+	}
+	for _, n := range info.Networks {
+		name := n.Name
+		if c.anonymize {
+			name = anonymizeValue(name)
+		}
+		send(&networkInfoDesc, ls.with("network", name).with("ip_address", n.IPAddress).with("mac", n.MacAddress), 1)
+	}
+	if info.User != nil {
+		user := *info.User
+		if c.anonymize {
+			user = anonymizeValue(user)
+		}
+		user, _ = sanitizeLabelValue(user)
+		send(&userInfoDesc, ls.with("user", user), 1)
+		send(&runsAsRootDesc, ls, b2f(runsAsRoot(*info.User)))
+	}
+	if c.commandInfo && (info.Entrypoint != nil || info.Cmd != nil) {
+		entrypoint := truncateOutput(strings.Join(info.Entrypoint, " "), maxCommandLabel)
+		cmd := truncateOutput(strings.Join(info.Cmd, " "), maxCommandLabel)
+		if c.anonymize {
+			entrypoint, cmd = anonymizeValue(entrypoint), anonymizeValue(cmd)
+		}
+		entrypoint, _ = sanitizeLabelValue(entrypoint)
+		cmd, _ = sanitizeLabelValue(cmd)
+		send(&commandInfoDesc, ls.with("entrypoint", entrypoint).with("cmd", cmd), 1)
+	}
+	// The environment is unknown in fast mode and without the config
+	// section, reporting 0 would hide the secrets it is meant to find.
+	if len(c.sensitiveEnv) > 0 && !c.fast && c.inspectFields.has("config") {
+		send(&envSensitiveVarsDesc, ls, float64(countSensitiveEnv(info.EnvNames, c.sensitiveEnv)))
+	}
+	for i := range c.derivedMetrics {
+		dm := &c.derivedMetrics[i]
+		send(&dm.desc, ls, b2f(dm.expr.Eval(containerEnv{&info})))
+	}
+	if len(c.severityRules) > 0 {
+		send(&severityDesc, ls, float64(containerSeverity(c.severityRules, &info)))
+	}
+	if c.logUnhealthy && info.Health == types.Unhealthy {
+		logUnhealthy(info, ls.value("name"), c.anonymize)
+	}
+	return errors.Join(errs...)
+}
 
-		for _, lv := range []string{"none", "starting", "healthy", "unhealthy"} {
-			tmpLabels := mapcopy(labels)
-			tmpLabels["status"] = lv
-			ch <- prometheus.MustNewConstMetric(healthStatusDesc.Desc(tmpLabels), prometheus.GaugeValue, b2f(info.State.Health.Status == lv))
+// collectImageEvents sends container_image_events_total.
+func (c *dockerHealthCollector) collectImageEvents(ch chan<- prometheus.Metric) {
+	for image, counts := range c.eventCounts.images {
+		if c.anonymize {
+			image = anonymizeValue(image)
 		}
-		for _, lv := range []string{"paused", "restarting", "running", "removing", "dead", "created", "exited"} {
-			tmpLabels := mapcopy(labels)
-			tmpLabels["status"] = lv
-			ch <- prometheus.MustNewConstMetric(statusDesc.Desc(tmpLabels), prometheus.GaugeValue, b2f(info.State.Status == lv))
+		image, _ = sanitizeLabelValue(image)
+		for _, action := range countedEvents {
+			ch <- prometheus.MustNewConstMetric(imageEventsTotalDesc.Desc(map[string]string{"image": image, "action": action}), prometheus.CounterValue, counts[action])
 		}
-		ch <- prometheus.MustNewConstMetric(oomkilledDesc.Desc(labels), prometheus.GaugeValue, b2f(info.State.OOMKilled))
-		startedat, err := time.Parse(time.RFC3339Nano, info.State.StartedAt)
-		errCheck(err)
-		finishedat, err := time.Parse(time.RFC3339Nano, info.State.FinishedAt)
-		errCheck(err)
-		ch <- prometheus.MustNewConstMetric(startedatDesc.Desc(labels), prometheus.GaugeValue, float64(startedat.Unix()))
-		ch <- prometheus.MustNewConstMetric(finishedatDesc.Desc(labels), prometheus.GaugeValue, float64(finishedat.Unix()))
-		ch <- prometheus.MustNewConstMetric(restartcountDesc.Desc(labels), prometheus.GaugeValue, float64(info.RestartCount))
 	}
 }
 
-func (c *dockerHealthCollector) collectContainer() {
-	containers, err := c.containerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
-	errCheck(err)
-	c.containerInfoCache = []types.ContainerJSON{}
+// collectRestartsTotal sends container_restarts_total for a container of the
+// cache. It is labeled by name only, to continue when the container is
+// recreated, so it is not sent for removed containers which may share the
+// name of a new one.
+func (c *dockerHealthCollector) collectRestartsTotal(ch chan<- prometheus.Metric, info *containerState) error {
+	m, err := restartsTotalDesc.metric(prometheus.CounterValue, c.restartTotals.total(info.Name), labelSet{}.with("name", info.metricLabels.value("name")))
+	if err != nil {
+		return err
+	}
+	ch <- m
+	return nil
+}
 
+// maxHealthOutput is the number of bytes of healthcheck output logged,
+// maxHealthOutputLabel the number exported by
+// container_state_health_last_output_info, maxErrorLabel the number of
+// bytes of errors exported by container_state_error_info and maxCommandLabel
+// the number of bytes of the entrypoint and command exported by
+// container_command_info.
+const (
+	maxHealthOutput      = 512
+	maxHealthOutputLabel = 128
+	maxErrorLabel        = 256
+	maxCommandLabel      = 256
+)
+
+// truncateOutput shortens a healthcheck output, an error or a command to at
+// most max bytes, without splitting a UTF-8 sequence.
+func truncateOutput(output string, max int) string {
+	if len(output) > max {
+		return strings.ToValidUTF8(output[:max], "") + "..."
+	}
+	return output
+}
+
+// logUnhealthy logs the last healthcheck output of an unhealthy container, at
+// most every few minutes per container. With anonymize, the output is hashed
+// as in the metrics, name must already be.
+func logUnhealthy(info containerState, name string, anonymize bool) {
+	output := truncateOutput(info.HealthOutput, maxHealthOutput)
+	if anonymize {
+		output = anonymizeValue(output)
+	}
+	unhealthyLogger.Log(info.ID, "message", "Container is unhealthy", "container", name, "id", info.ID, "failing_streak", info.FailingStreak, "output", output)
+}
+
+// collectContainer inspects all containers and returns the new cache. With
+// -collector.incremental, containers whose list entry is unchanged since the
+// previous refresh are not inspected again, unless their healthcheck is due.
+// With -collector.fast, none is.
+// Containers removed between listing and inspection are skipped; any other
+// failure is returned. For containers that failed to be inspected, and when
+// listing fails entirely, the previous state is kept so it can still be
+// exported.
+func (c *dockerHealthCollector) collectContainer(ctx context.Context, previous []containerState) ([]containerState, error) {
+	begin := time.Now()
+	// In fast mode, the sizes can only come from the list.
+	containers, err := c.containerClient.ContainerList(ctx, types.ContainerListOptions{All: true, Size: c.sizes && c.fast})
+	c.phaseDurations[phaseList].Store(int64(time.Since(begin)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDaemonUnreachable, err)
+	}
+	previousByID := map[string]containerState{}
+	for _, info := range previous {
+		previousByID[info.ID] = info
+	}
+	cache := make([]containerState, 0, len(containers))
+
+	containers = c.shard.filter(containers)
+	if c.fast {
+		// Until the capabilities are probed, parse the health statuses
+		// anyway, the list of older daemons has none.
+		healthInList := true
+		if caps := c.capabilities.Load(); caps != nil {
+			healthInList = caps.healthInList
+		}
+		for _, container := range containers {
+			info := containerFromSummary(container, healthInList)
+			if c.sizes {
+				sizeRw, sizeRootFs := container.SizeRw, container.SizeRootFs
+				info.SizeRw, info.SizeRootFs = &sizeRw, &sizeRootFs
+			}
+			cache = append(cache, info)
+		}
+		c.phaseDurations[phaseInspect].Store(0)
+		return cache, nil
+	}
+	summaries := make(map[string]string, len(containers))
+	var changed []types.Container
+	for _, container := range containers {
+		summaries[container.ID] = containerSummary(container)
+		if info, ok := previousByID[container.ID]; ok && c.incremental && c.summaries[container.ID] == summaries[container.ID] && !healthcheckDue(info, begin) {
+			continue
+		}
+		changed = append(changed, container)
+	}
+	begin = time.Now()
+	results := map[string]inspectResult{}
+	for i, result := range c.inspectAll(ctx, changed) {
+		results[changed[i].ID] = result
+	}
+	c.phaseDurations[phaseInspect].Store(int64(time.Since(begin)))
+
+	var errs []error
 	for _, container := range containers {
-		info, err := c.containerClient.ContainerInspect(context.Background(), container.ID)
-		errCheck(err)
-		c.containerInfoCache = append(c.containerInfoCache, info)
+		result, inspected := results[container.ID]
+		if !inspected {
+			inspectsSkippedTotal.Inc()
+			cache = append(cache, previousByID[container.ID])
+			continue
+		}
+		if err := result.err; err != nil {
+			// Inspect it again on the next refresh.
+			delete(summaries, container.ID)
+			if client.IsErrNotFound(err) {
+				continue
+			}
+			errs = append(errs, fmt.Errorf("inspect %s: %w", container.ID, err))
+			if info, ok := previousByID[container.ID]; ok {
+				cache = append(cache, info)
+			}
+			continue
+		}
+		state, err := result.state()
+		if err != nil {
+			delete(summaries, container.ID)
+			errs = append(errs, fmt.Errorf("inspect %s: %w", container.ID, err))
+			if info, ok := previousByID[container.ID]; ok {
+				cache = append(cache, info)
+			}
+			continue
+		}
+		cache = append(cache, state)
+	}
+	c.summaries = summaries
+	c.images.retain(imageIDs(cache))
+	return cache, errors.Join(errs...)
+}
+
+// containerSummary returns the parts of a container list entry that change
+// along with the container. Status holds the health status and a coarse
+// uptime, such as "Up 5 minutes (healthy)", which also changes on restarts.
+// healthcheckDue tells whether a healthcheck of a running container should
+// have run since the last one the cache knows of. The list entry only shows
+// the health status, so without inspecting again the failing streak and the
+// time of the last check would freeze, and checks would seem to have stopped.
+func healthcheckDue(info containerState, now time.Time) bool {
+	hc := info.Healthcheck
+	return info.Status == "running" && hc != nil && hc.Configured && !now.Before(info.HealthCheckedAt.Add(hc.Interval))
+}
+
+func containerSummary(container types.Container) string {
+	return strings.Join([]string{container.State, container.Status, container.Image, strings.Join(container.Names, ",")}, "\x00")
+}
+
+type inspectResult struct {
+	info types.ContainerJSON
+	err  error
+	// processes is the number of processes of the container with
+	// -collector.processes, 0 if unknown.
+	processes int
+	image     imageDetails
+}
+
+// state converts the result of a successful inspection. A malformed
+// response that makes the conversion panic yields errPanicked.
+func (r *inspectResult) state() (s containerState, err error) {
+	err = errPanicked
+	defer recoverPanic("converting an inspect response")
+	s = newContainerState(&r.info)
+	s.Processes = r.processes
+	s.ImageDetails = r.image
+	return s, nil
+}
+
+// countProcesses returns the number of processes of a running container, or
+// 0 if it is not running or cannot be listed, such as when it just stopped.
+func (c *dockerHealthCollector) countProcesses(ctx context.Context, info *types.ContainerJSON) int {
+	if info.ContainerJSONBase == nil || info.State == nil || !info.State.Running {
+		return 0
+	}
+	top, err := c.containerClient.ContainerTop(ctx, info.ID, nil)
+	if err != nil {
+		return 0
+	}
+	return len(top.Processes)
+}
+
+// inspectAll inspects the containers with a pool of c.workers goroutines and
+// returns the results in the same order.
+func (c *dockerHealthCollector) inspectAll(ctx context.Context, containers []types.Container) []inspectResult {
+	results := make([]inspectResult, len(containers))
+	c.reportSyncProgress(0, len(containers))
+
+	var mu sync.Mutex
+	done := 0
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < c.workers && w < len(containers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = c.inspectOne(ctx, containers[i].ID)
+				mu.Lock()
+				done++
+				c.reportSyncProgress(done, len(containers))
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range containers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// inspectOne inspects a container for inspectAll. A panic only fails this
+// container, the worker goes on with the others.
+func (c *dockerHealthCollector) inspectOne(ctx context.Context, id string) (result inspectResult) {
+	result.err = errPanicked
+	defer recoverPanic("inspect " + id)
+	info, err := c.inspect(ctx, id)
+	result = inspectResult{info: info, err: err}
+	if err == nil && c.processes {
+		result.processes = c.countProcesses(ctx, &info)
+	}
+	if err == nil && info.ContainerJSONBase != nil {
+		result.image = c.imageDetails(ctx, info.Image)
+	}
+	return result
+}
+
+// reportSyncProgress records that done of total containers have been
+// inspected while the initial sync is running, and logs the progress every
+// few seconds. It does nothing once the initial sync is complete.
+func (c *dockerHealthCollector) reportSyncProgress(done, total int) {
+	if c.synced.Load() {
+		return
+	}
+	c.syncDone.Store(int64(done))
+	c.syncTotal.Store(int64(total))
+	now := time.Now()
+	if c.syncLogged.IsZero() {
+		c.syncLogged = now
+		normalLogger.Log("message", "Initial sync started", "containers", total)
+	} else if now.Sub(c.syncLogged) >= 5*time.Second {
+		c.syncLogged = now
+		normalLogger.Log("message", "Initial sync in progress", "inspected", done, "containers", total)
+	}
+}
+
+// syncProgressPercent returns the progress of the initial sync.
+func (c *dockerHealthCollector) syncProgressPercent() float64 {
+	if c.synced.Load() {
+		return 100
+	}
+	total := c.syncTotal.Load()
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(c.syncDone.Load()) / float64(total)
+}
 
-		if info.Config == nil {
-			info.Config = &tcontainer.Config{Labels: map[string]string{}}
+// inspect inspects a container, decoding only the fields selected by
+// -inspect.fields. Transient errors, typical of an overloaded daemon, are
+// retried with a jittered backoff as long as ctx allows.
+func (c *dockerHealthCollector) inspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	for attempt := 0; ; attempt++ {
+		var info types.ContainerJSON
+		var err error
+		if c.inspectFields == nil {
+			info, _, err = c.containerClient.ContainerInspectWithRaw(ctx, id, c.sizes)
+		} else {
+			info, err = inspectProjected(ctx, c.containerClient, id, c.inspectFields, c.sizes)
+		}
+		if err == nil || attempt >= c.inspectRetries || !isTransientError(err) {
+			return info, err
 		}
 
-		if info.State.Health == nil {
-			info.State.Health = &types.Health{Status: "none"}
+		backoff := time.Duration(float64(50*time.Millisecond<<attempt) * (0.5 + rand.Float64()))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return info, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return info, err
 		}
+		inspectRetriesTotal.Inc()
+	}
+}
+
+// isTransientError reports whether err is a connection failure worth
+// retrying, such as a reset connection or an unexpected EOF.
+func isTransientError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	msg := err.Error()
+	return strings.HasSuffix(msg, "EOF") || strings.Contains(msg, "connection reset by peer")
+}
+
+// parseTimestamp converts a docker timestamp to Unix seconds. Missing and zero
+// timestamps, as reported for containers that never started or finished,
+// yield 0.
+func parseTimestamp(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	if t.IsZero() {
+		return 0, nil
 	}
+	return float64(t.Unix()), nil
 }
 
 type loggerWrapper struct {
@@ -161,12 +1673,95 @@ func (l *loggerWrapper) Println(v ...interface{}) {
 	(*l.Logger).Log("messages", v)
 }
 
+// rateLimitedLogger logs at most once per interval for each key.
+type rateLimitedLogger struct {
+	logger   *log.Logger
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newRateLimitedLogger(logger *log.Logger, interval time.Duration) *rateLimitedLogger {
+	return &rateLimitedLogger{logger: logger, interval: interval, last: map[string]time.Time{}}
+}
+
+// Log logs keyvals unless something was logged for key within the interval.
+func (l *rateLimitedLogger) Log(key string, keyvals ...interface{}) {
+	l.mu.Lock()
+	now := time.Now()
+	for k, t := range l.last {
+		if now.Sub(t) >= l.interval {
+			delete(l.last, k)
+		}
+	}
+	_, limited := l.last[key]
+	if !limited {
+		l.last[key] = now
+	}
+	l.mu.Unlock()
+	if !limited {
+		(*l.logger).Log(keyvals...)
+	}
+}
+
 // Define loggers.
 var (
 	normalLogger = log.NewJSONLogger(log.NewSyncWriter(os.Stdout))
+	warnLogger   = log.NewJSONLogger(log.NewSyncWriter(os.Stderr))
 	errorLogger  = log.NewJSONLogger(log.NewSyncWriter(os.Stderr))
+
+	labelWarningLogger = newRateLimitedLogger(&warnLogger, time.Minute)
+	unhealthyLogger    = newRateLimitedLogger(&warnLogger, 5*time.Minute)
+)
+
+// Define self metrics.
+var (
+	collectErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_collect_errors_total",
+		Help: "Number of collector runs that failed.",
+	})
+	collectorPanicsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_collector_panics_total",
+		Help: "Number of panics recovered while collecting container metrics.",
+	})
+	scrapeDeadlineExceededTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_scrape_deadline_exceeded_total",
+		Help: "Number of scrapes that returned partial data because the scrape deadline was exceeded.",
+	})
+	apiThrottledSecondsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_api_throttled_seconds_total",
+		Help: "Time docker API calls waited for -docker.max-requests-per-second.",
+	})
+	inspectsSkippedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_inspects_skipped_total",
+		Help: "Number of container inspections skipped because the container was unchanged.",
+	})
+	inspectRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_inspect_retries_total",
+		Help: "Number of container inspections retried after a transient error.",
+	})
+	daemonOutagesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_daemon_outages_total",
+		Help: "Number of times the docker daemon became unreachable.",
+	})
+	labelValuesSanitizedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "docker_exporter_label_values_sanitized_total",
+		Help: "Number of label values that had to be sanitized because of invalid UTF-8 or control characters.",
+	})
 )
 
+// recoverPanic must be deferred. It logs a recovered panic with its stack and
+// counts it, so the metrics collected so far are still returned.
+func recoverPanic(where string) {
+	if r := recover(); r != nil {
+		errorLogger.Log("message", fmt.Sprintf("Recovered from panic in %s: %v", where, r), "stack", string(debug.Stack()))
+		collectorPanicsTotal.Inc()
+	}
+}
+
+// errCheck terminates the process on error. It must only be used for startup
+// configuration errors, never on the collection path.
 func errCheck(err error) {
 	if err != nil {
 		errorLogger.Log("message", err)
@@ -176,46 +1771,236 @@ func errCheck(err error) {
 
 // Define flags.
 var (
-	address = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
+	address                = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
+	checkConfig            = flag.Bool("check-config", false, "Validate the flags and the config file, then exit with 0 if they are valid or 1 otherwise.")
+	configFile             = flag.String("config.file", "", "Path to an optional YAML configuration file.")
+	stateTimeout           = flag.Duration("collector.state.timeout", 10*time.Second, "Timeout of the container state collector.")
+	daemonTimeout          = flag.Duration("collector.daemon.timeout", 10*time.Second, "Timeout of the docker daemon collector.")
+	infoTimeout            = flag.Duration("collector.info.timeout", 10*time.Second, "Timeout of the docker info collector.")
+	procfs                 = flag.String("path.procfs", "/proc", "Mount point of the host procfs.")
+	rootfs                 = flag.String("path.rootfs", "/", "Mount point of the host root filesystem, to read the log files of containers.")
+	cacheDuration          = flag.Duration("collector.cache-duration", time.Second, "How long the results of docker inspect are reused between scrapes. 0 disables the cache.")
+	removedTTL             = flag.Duration("collector.removed-ttl", 0, "How long the last known state of removed containers keeps being exported. 0 stops exporting them at once.")
+	watchEvents            = flag.Bool("collector.events", false, "Keep the container state up to date from the docker event stream, instead of inspecting every container on each scrape.")
+	resyncInterval         = flag.Duration("collector.events.resync-interval", 5*time.Minute, "Interval between full refreshes of the container state when -collector.events is enabled.")
+	countersKey            = flag.String("collector.counters-key", "id", "Whether the counters of containers are kept by container id, or by name to carry them over to a new container with the same name: id or name.")
+	restartsWindow         = flag.Duration("collector.restarts-window", 5*time.Minute, "Window of container_restarts_recent, the number of recent restarts of containers. Requires -collector.events.")
+	pollInterval           = flag.Duration("collector.poll-interval", 0, "Interval between background collections of the container state. 0 collects it on scrape.")
+	maxRequests            = flag.Int("web.max-requests", 40, "Maximum number of concurrent scrape requests. 0 disables the limit.")
+	timeoutOffset          = flag.Duration("web.timeout-offset", 500*time.Millisecond, "Offset to subtract from the scrape timeout announced by Prometheus.")
+	etag                   = flag.Bool("web.etag", false, "Answer scrapes with 304 Not Modified while the container state is unchanged. The other metrics, including the time based gauges, are then only rendered again with the containers. Requires -collector.poll-interval.")
+	enablePprof            = flag.Bool("web.enable-pprof", false, "Expose the Go profiling endpoints under /debug/pprof/.")
+	enableDebug            = flag.Bool("web.enable-debug", false, "Expose the diagnostics dump, with the goroutine stacks and the last collection error, under /-/debug. A SIGQUIT always logs it.")
+	disableExporterMetrics = flag.Bool("web.disable-exporter-metrics", false, "Exclude the Go runtime and process metrics of the exporter itself (go_*, process_*) from /metrics. go_build_info, which tells the version of the exporter, is kept.")
+	shardIndex             = flag.Int("shard.index", 0, "Index of this exporter among -shard.total exporters splitting the containers of the host.")
+	shardTotal             = flag.Int("shard.total", 1, "Number of exporters splitting the containers of the host.")
+	shardSpec              = flag.String("collector.shard", "", "Shard of the containers exported, as N/M for the N-th of M exporters, N from 0 to M-1. Shorthand for -shard.index and -shard.total.")
+	snapshotFile           = flag.String("collector.snapshot-file", "", "File the container data is saved to, and restored from at startup so the first scrapes after a restart export the last known state. Empty disables it.")
+	uptimeHistogramFlag    = flag.Bool("collector.uptime-histogram", false, "Export container_uptime_seconds, a histogram of the uptime of the running containers.")
+	stateEncoding          = flag.String("metrics.state-encoding", "labels", "How the status and health status of containers are exported: labels, one series per possible status, or enum, a single series whose value maps to the status.")
+	fieldsFlag             = flag.String("inspect.fields", "all", "Comma separated sections of docker inspect to decode and retain: "+strings.Join(inspectSections, ",")+" or all.")
+	routingPrefix          = flag.String("collector.routing-label-prefix", "docker_state_exporter.", "Container labels with this prefix are also exported as alert_<rest of the label> on every series. Empty disables it.")
+	anonymize              = flag.Bool("anonymize", false, "Replace container names, image names and label values by hashes, to share outputs in bug reports.")
+	logUnhealthyFlag       = flag.Bool("log.unhealthy-output", false, "Log the last healthcheck output of unhealthy containers at warning level, at most every 5 minutes per container.")
+	healthOutputInfo       = flag.Bool("collector.health-output-info", false, "Export container_state_health_last_output_info, with the last healthcheck output of containers truncated to 128 bytes as label.")
+	commandInfo            = flag.Bool("collector.command-info", false, "Export container_command_info, with the entrypoint and command of containers truncated to 256 bytes as labels.")
+	mountInfo              = flag.Bool("collector.mount-info", false, "Export container_mount_info, with the type, source, destination and read-write mode of every mount of containers as labels.")
+	processesFlag          = flag.Bool("collector.processes", false, "Export container_processes, the number of processes of running containers. Lists the processes of every running container on each collection.")
+	sizesFlag              = flag.Bool("collector.size", false, "Export container_fs_rw_bytes and container_fs_rootfs_bytes, the sizes of the filesystem of containers. Makes the daemon compute them on every inspection, which is slow for large containers.")
+	imagesFlag             = flag.Bool("collector.images", true, "Inspect the images of the containers, once per image, for the image metrics. Disabled by -collector.fast.")
+	retries                = flag.Int("docker.inspect-retries", 2, "Number of times an inspect failing with a transient error is retried.")
+	maxAPIRate             = flag.Float64("docker.max-requests-per-second", 0, "Maximum number of docker API calls per second. 0 disables the limit.")
+	workers                = flag.Int("collector.workers", 8, "Number of containers inspected concurrently.")
+	incremental            = flag.Bool("collector.incremental", false, "Only inspect again the containers whose state or status changed in the container list since the previous collection.")
+	fast                   = flag.Bool("collector.fast", false, "Only use the container list, without inspecting the containers. Much cheaper on very large hosts, but some metrics become unavailable.")
+	streaming              = flag.Bool("collector.streaming", false, "Inspect the containers on each scrape -collector.workers at a time and emit their metrics right away, without caching them. Bounds memory on hosts with thousands of containers.")
+	compressCacheFlag      = flag.Bool("collector.compress-cache", false, "Keep the cached container states compressed, decoding them on every scrape. Trades CPU for memory on hosts with thousands of containers.")
+	breakerThreshold       = flag.Int("docker.circuit-breaker.threshold", 5, "Number of consecutive failed collections after which calls to the docker daemon are suspended. 0 disables the circuit breaker.")
+	breakerProbe           = flag.Duration("docker.circuit-breaker.probe-interval", 30*time.Second, "Interval between probes of the docker daemon while the circuit breaker is open.")
+	outageGrace            = flag.Duration("docker.outage-grace-period", 0, "How long the last known state is served while the docker daemon is unreachable. 0 serves it until the daemon is back.")
 )
 
 func init() {
 	normalLogger = log.With(normalLogger, "timestamp", log.DefaultTimestampUTC)
 	normalLogger = log.With(normalLogger, "severity", "info")
+	warnLogger = log.With(warnLogger, "timestamp", log.DefaultTimestampUTC)
+	warnLogger = log.With(warnLogger, "severity", "warning")
 	errorLogger = log.With(errorLogger, "timestamp", log.DefaultTimestampUTC)
 	errorLogger = log.With(errorLogger, "severity", "error")
-	prometheus.MustRegister(prometheus.NewBuildInfoCollector())
+	prometheus.MustRegister(collectors.NewBuildInfoCollector())
+	prometheus.MustRegister(collectErrorsTotal)
+	prometheus.MustRegister(collectorPanicsTotal)
+	prometheus.MustRegister(labelValuesSanitizedTotal)
+	prometheus.MustRegister(daemonOutagesTotal)
+	prometheus.MustRegister(scrapeDeadlineExceededTotal)
+	prometheus.MustRegister(inspectRetriesTotal)
+	prometheus.MustRegister(inspectsSkippedTotal)
+	prometheus.MustRegister(apiThrottledSecondsTotal)
+	prometheus.MustRegister(loopRestartsTotal)
+}
+
+// shardFromFlags returns the shard given by -collector.shard, or else by
+// -shard.index and -shard.total.
+func shardFromFlags() (shard, error) {
+	if *shardSpec != "" {
+		return parseShard(*shardSpec)
+	}
+	return newShard(*shardIndex, *shardTotal)
 }
 
 func main() {
+	if runServiceCommand(os.Args[1:]) {
+		return
+	}
 	flag.Parse()
 
-	client, err := client.NewEnvClient()
+	if err := validateFlags(); err != nil {
+		errorLogger.Log("message", fmt.Sprintf("Invalid configuration:\n%v", err))
+		os.Exit(1)
+	}
+	if *checkConfig {
+		normalLogger.Log("message", "Configuration is valid")
+		os.Exit(0)
+	}
+
+	if *disableExporterMetrics {
+		prometheus.Unregister(collectors.NewGoCollector())
+		prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	cfg, err := loadConfig(*configFile)
+	errCheck(err)
+	derivedMetrics, err := newDerivedMetrics(cfg.DerivedMetrics, *fast)
+	errCheck(err)
+
+	client, err := newDockerClient(*maxAPIRate)
 	errCheck(err)
 	defer client.Close()
 
-	_, err = client.Ping(context.Background())
+	ping, err := client.Ping(context.Background())
 	errCheck(err)
+	// Negotiate before the workers send concurrent requests, also for
+	// inspectProjected, which builds its paths from the client version.
+	client.NegotiateAPIVersionPing(ping)
 
-	prometheus.MustRegister(&dockerHealthCollector{
-		containerClient: client,
-	})
+	shard, err := shardFromFlags()
+	errCheck(err)
+	fields, err := parseInspectFields(*fieldsFlag)
+	errCheck(err)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	state := &dockerHealthCollector{
+		containerClient:    client,
+		workers:            *workers,
+		incremental:        *incremental,
+		fast:               *fast,
+		streaming:          *streaming,
+		compress:           *compressCacheFlag,
+		cachePeriod:        *cacheDuration,
+		refreshTimeout:     *stateTimeout,
+		removedTTL:         *removedTTL,
+		removed:            map[string]removedContainer{},
+		dirty:              map[string]bool{},
+		outageGracePeriod:  *outageGrace,
+		derivedMetrics:     derivedMetrics,
+		severityRules:      cfg.SeverityRules,
+		sensitiveEnv:       cfg.SensitiveEnvPatterns,
+		shard:              shard,
+		inspectFields:      fields,
+		inspectRetries:     *retries,
+		routingLabelPrefix: *routingPrefix,
+		anonymize:          *anonymize,
+		logUnhealthy:       *logUnhealthyFlag,
+		procfs:             *procfs,
+		rootfs:             *rootfs,
+		processes:          *processesFlag,
+		sizes:              *sizesFlag,
+		inspectImages:      *imagesFlag && !*fast,
+		healthOutputInfo:   *healthOutputInfo,
+		commandInfo:        *commandInfo,
+		mountInfo:          *mountInfo,
+		countersByName:     *countersKey == "name",
+		restarts:           restartTracker{window: *restartsWindow},
+		snapshotFile:       *snapshotFile,
+		exportUptime:       *uptimeHistogramFlag,
+		stateEnum:          *stateEncoding == "enum",
+		breaker: circuitBreaker{
+			threshold:     *breakerThreshold,
+			probeInterval: *breakerProbe,
+		},
+	}
+	state.probeCapabilities(context.Background())
+	if state.snapshotFile != "" {
+		state.restoreSnapshot()
+	}
+	exporter := newExporter(
+		namedCollector{"state", *stateTimeout, state},
+		namedCollector{"daemon", *daemonTimeout, &daemonCollector{procfs: *procfs, cli: client, state: state}},
+		namedCollector{"info", *infoTimeout, &infoCollector{cli: client, cachePeriod: *cacheDuration, state: state}},
+	)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "dockerstate_initial_sync_complete",
+		Help: "Whether the initial inspection of all containers is complete.",
+	}, func() float64 { return b2f(state.synced.Load()) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "dockerstate_initial_sync_progress_percent",
+		Help: "Percentage of containers inspected by the initial sync.",
+	}, state.syncProgressPercent))
+	if *watchEvents {
+		state.events = true
+		state.resyncInterval = *resyncInterval
+		supervise("events", eventsHeartbeatInterval, state.watchEvents)
+	}
+	if *pollInterval > 0 {
+		state.pollInterval = *pollInterval
+		state.pollTimeout = *stateTimeout
+		supervise("poller", *pollInterval+*stateTimeout, state.poll)
+	} else if *streaming {
+		// Every scrape inspects all containers, there is no initial sync.
+		state.synced.Store(true)
+	} else {
+		go state.refresh(context.Background())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "<h1>docker state exporter</h1>")
 	})
 
-	http.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "up")
 	})
 
-	http.Handle("/metrics", promhttp.HandlerFor(
-		prometheus.DefaultGatherer,
+	if *enableDebug {
+		mux.Handle("/-/debug", debugHandler(state))
+	}
+	go dumpDiagnosticsOnSignal(state)
+
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !state.synced.Load() {
+			http.Error(w, "initial sync in progress", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "ready")
+	})
+
+	var generation func() int64
+	if *etag {
+		generation = state.generation.Load
+	}
+	mux.Handle("/metrics", metricsHandler(exporter, *timeoutOffset, *maxRequests, generation,
 		promhttp.HandlerOpts{ErrorLog: &loggerWrapper{Logger: &errorLogger}, EnableOpenMetrics: true}))
 
+	if *enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
 	normalLogger.Log("message", "Server listening...", "address", address)
 
-	server := &http.Server{Addr: *address, Handler: nil}
+	server := &http.Server{Addr: *address, Handler: mux}
 
 	go func() {
 		err = server.ListenAndServe()
@@ -226,6 +2011,9 @@ func main() {
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, os.Interrupt)
+	if serviceControl != nil {
+		go serviceControl(quit)
+	}
 	<-quit
 	normalLogger.Log("message", "Server shutting down...")
 
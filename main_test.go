@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0001-01-01T00:00:00Z", 0, false},
+		{"2023-01-02T03:04:05Z", 1672628645, false},
+		{"2023-01-02T03:04:05.999999999Z", 1672628645, false},
+		{"2023-01-02T05:04:05+02:00", 1672628645, false},
+		{"2023-01-02", 0, true},
+		{"not a timestamp", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimestamp(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+// benchmarkCache returns the cache of a host with n containers sharing a few
+// images and compose projects.
+func benchmarkCache(n int) []containerState {
+	cache := make([]containerState, n)
+	for i := range cache {
+		cache[i] = containerState{
+			ID:      fmt.Sprintf("%064x", i),
+			Name:    fmt.Sprintf("app-%d", i),
+			Image:   fmt.Sprintf("registry.example.com/team/app-%d:latest", i%10),
+			ImageID: fmt.Sprintf("sha256:%064x", i%10),
+			Created: "2023-01-02T03:04:05Z",
+			Labels: map[string]string{
+				"com.docker.compose.project": fmt.Sprintf("project-%d", i%20),
+				"com.docker.compose.service": fmt.Sprintf("service-%d", i%50),
+				"maintainer":                 "team@example.com",
+			},
+			Status:     "running",
+			Pid:        1000 + i,
+			StartedAt:  "2023-01-02T03:04:06Z",
+			FinishedAt: "0001-01-01T00:00:00Z",
+			Health:     "healthy",
+		}
+	}
+	return cache
+}
+
+// BenchmarkCollectMetrics measures a scrape of the cache. The labels of the
+// containers are built once when they enter the cache, the "uncached"
+// variant builds them again on every scrape as before.
+func BenchmarkCollectMetrics(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := &dockerHealthCollector{removed: map[string]removedContainer{}}
+			c.setCache(benchmarkCache(1000), time.Now())
+			if !cached {
+				for i := range c.containerInfoCache {
+					c.containerInfoCache[i].metricLabels = labelSet{}
+				}
+			}
+			ch := make(chan prometheus.Metric, 1024)
+			done := make(chan struct{})
+			go func() {
+				for range ch {
+				}
+				close(done)
+			}()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.collectMetrics(ch); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			close(ch)
+			<-done
+		})
+	}
+}
+
+func TestInspectOnePanic(t *testing.T) {
+	// Without a client, the inspection panics.
+	c := &dockerHealthCollector{}
+	if r := c.inspectOne(context.Background(), "aaa111"); r.err != errPanicked {
+		t.Errorf("inspectOne() error = %v, want %v", r.err, errPanicked)
+	}
+}
+
+func TestHealthcheckDue(t *testing.T) {
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	hc := &healthcheck{Configured: true, Interval: 30 * time.Second}
+	for _, tt := range []struct {
+		name string
+		info containerState
+		want bool
+	}{
+		{"checked recently", containerState{Status: "running", Healthcheck: hc, HealthCheckedAt: now.Add(-10 * time.Second)}, false},
+		{"interval passed", containerState{Status: "running", Healthcheck: hc, HealthCheckedAt: now.Add(-30 * time.Second)}, true},
+		{"never checked", containerState{Status: "running", Healthcheck: hc}, true},
+		{"not running", containerState{Status: "exited", Healthcheck: hc}, false},
+		{"no healthcheck", containerState{Status: "running", Healthcheck: &healthcheck{}}, false},
+		{"unknown configuration", containerState{Status: "running"}, false},
+	} {
+		if got := healthcheckDue(tt.info, now); got != tt.want {
+			t.Errorf("%s: healthcheckDue() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetCacheGeneration(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		now := time.Now()
+		c := &dockerHealthCollector{removed: map[string]removedContainer{}, removedTTL: time.Minute, compress: compress}
+		c.setCache(benchmarkCache(3), now)
+		generation := c.generation.Load()
+
+		c.setCache(benchmarkCache(3), now.Add(time.Second))
+		if got := c.generation.Load(); got != generation {
+			t.Errorf("compress %v: generation changed to %d with the same containers, want %d", compress, got, generation)
+		}
+
+		changed := benchmarkCache(3)
+		changed[1].Health = "unhealthy"
+		c.setCache(changed, now.Add(2*time.Second))
+		if got := c.generation.Load(); got == generation {
+			t.Errorf("compress %v: generation unchanged with a changed container", compress)
+		}
+		generation = c.generation.Load()
+
+		c.setCache(changed[:2], now.Add(3*time.Second))
+		c.setCache(changed[:2], now.Add(4*time.Second))
+		if got := c.generation.Load(); got != generation+1 {
+			t.Errorf("compress %v: generation = %d after a removal, want %d", compress, got, generation+1)
+		}
+		// The removed container expires.
+		c.setCache(changed[:2], now.Add(2*time.Minute))
+		if got := c.generation.Load(); got != generation+2 {
+			t.Errorf("compress %v: generation = %d after an expiry, want %d", compress, got, generation+2)
+		}
+	}
+}
+
+func TestCollectMetricsAnonymize(t *testing.T) {
+	c := &dockerHealthCollector{removed: map[string]removedContainer{}, anonymize: true, healthOutputInfo: true}
+	cache := benchmarkCache(1)
+	cache[0].HealthOutput = "secret-output"
+	cache[0].HealthCheckedAt = time.Date(2023, 1, 2, 3, 5, 0, 0, time.UTC)
+	cache[0].Networks = []attachedNetwork{{Name: "secret-network"}}
+	cache[0].Host = &hostSettings{SecurityOpt: []securityOpt{{Option: "seccomp", Value: "/etc/secret-profile.json"}}}
+	c.setCache(cache, time.Now())
+	for _, m := range collectedMetrics(t, c) {
+		if strings.Contains(m, "secret") || strings.Contains(m, "app-0") {
+			t.Errorf("metric not anonymized: %s", m)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+// publishedPort is a port of a container published on the host.
+type publishedPort struct {
+	ContainerPort string `json:"container_port"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"host_ip"`
+	HostPort      string `json:"host_port"`
+}
+
+// newPublishedPorts converts the port bindings of the network settings.
+// Exposed ports that are not published have no binding and are left out.
+func newPublishedPorts(settings *types.NetworkSettings) []publishedPort {
+	var ports []publishedPort
+	for port, bindings := range settings.Ports {
+		for _, binding := range bindings {
+			ports = append(ports, publishedPort{
+				ContainerPort: port.Port(),
+				Protocol:      port.Proto(),
+				HostIP:        binding.HostIP,
+				HostPort:      binding.HostPort,
+			})
+		}
+	}
+	sortPorts(ports)
+	return ports
+}
+
+// summaryPorts converts the ports of a container list entry, for
+// -collector.fast.
+func summaryPorts(list []types.Port) []publishedPort {
+	var ports []publishedPort
+	for _, port := range list {
+		if port.PublicPort == 0 {
+			continue
+		}
+		ports = append(ports, publishedPort{
+			ContainerPort: strconv.Itoa(int(port.PrivatePort)),
+			Protocol:      port.Type,
+			HostIP:        port.IP,
+			HostPort:      strconv.Itoa(int(port.PublicPort)),
+		})
+	}
+	sortPorts(ports)
+	return ports
+}
+
+func sortPorts(ports []publishedPort) {
+	sort.Slice(ports, func(i, j int) bool {
+		a, b := ports[i], ports[j]
+		if a.ContainerPort != b.ContainerPort {
+			return a.ContainerPort < b.ContainerPort
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.HostIP < b.HostIP
+	})
+}
+
+// attachedNetwork is a network a container is attached to.
+type attachedNetwork struct {
+	Name       string `json:"name"`
+	IPAddress  string `json:"ip_address,omitempty"`
+	MacAddress string `json:"mac_address,omitempty"`
+}
+
+// newAttachedNetworks converts the endpoints of a container by network name,
+// sorted by name. Containers with the none network mode are attached to the
+// none network.
+func newAttachedNetworks(endpoints map[string]*network.EndpointSettings) []attachedNetwork {
+	networks := make([]attachedNetwork, 0, len(endpoints))
+	for name, endpoint := range endpoints {
+		n := attachedNetwork{Name: name}
+		if endpoint != nil {
+			n.IPAddress = endpoint.IPAddress
+			n.MacAddress = endpoint.MacAddress
+		}
+		networks = append(networks, n)
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	return networks
+}
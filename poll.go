@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// poll refreshes the cache every pollInterval, so scrapes only read the
+// latest snapshot and never wait for the daemon. Each refresh is bounded by
+// pollTimeout.
+func (c *dockerHealthCollector) poll(ctx context.Context, heartbeat func()) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, c.pollTimeout)
+		c.refresh(pollCtx)
+		cancel()
+		heartbeat()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
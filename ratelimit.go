@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// newDockerClient creates a docker client configured from the environment,
+// negotiating the API version with the daemon unless DOCKER_API_VERSION
+// sets it. When maxRate is positive, its calls to the daemon are spaced to at most
+// maxRate per second.
+func newDockerClient(maxRate float64) (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil || maxRate <= 0 {
+		return cli, err
+	}
+	// The transport configured for the daemon host is reused, wrapped.
+	httpClient := cli.HTTPClient()
+	scheme := "http"
+	if tr, ok := httpClient.Transport.(*http.Transport); ok && tr.TLSClientConfig != nil {
+		scheme = "https"
+	}
+	httpClient.Transport = &rateLimitedTransport{
+		base:     httpClient.Transport,
+		interval: time.Duration(float64(time.Second) / maxRate),
+	}
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation(), client.WithHTTPClient(httpClient), client.WithScheme(scheme))
+}
+
+// rateLimitedTransport delays requests so they start at least interval
+// apart. Waiting requests give up when their context is done.
+type rateLimitedTransport struct {
+	base     http.RoundTripper
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		apiThrottledSecondsTotal.Add(delay.Seconds())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// baseTransport returns the transport wrapped by a rateLimitedTransport.
+func baseTransport(rt http.RoundTripper) http.RoundTripper {
+	if t, ok := rt.(*rateLimitedTransport); ok {
+		return t.base
+	}
+	return rt
+}
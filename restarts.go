@@ -0,0 +1,206 @@
+package main
+
+import "time"
+
+// restartCauses are the probable causes of a restart, by priority. Restarts
+// after a clean exit, for example with the always restart policy, are
+// counted as other.
+var restartCauses = []string{"oom", "healthcheck", "manual", "nonzero_exit", "other"}
+
+// lifecycle is what happened to a container since it last started.
+type lifecycle struct {
+	died      bool
+	oom       bool
+	unhealthy bool
+	manual    bool
+	exitCode  string
+}
+
+func (l *lifecycle) cause() string {
+	switch {
+	case l.oom:
+		return "oom"
+	case l.unhealthy:
+		return "healthcheck"
+	case l.manual:
+		return "manual"
+	case l.exitCode != "" && l.exitCode != "0":
+		return "nonzero_exit"
+	default:
+		return "other"
+	}
+}
+
+// restartTracker counts the restarts of every container by probable cause,
+// from the events preceding each start, and keeps the times of the restarts
+// within window. It also counts OOM kills, restarted or not. Containers are
+// identified by their counter key, see -collector.counters-key.
+type restartTracker struct {
+	window     time.Duration
+	lifecycles map[string]*lifecycle
+	counts     map[string]map[string]float64
+	recent     map[string][]time.Time
+	oomKills   map[string]float64
+}
+
+// observe records a container event that happened at the given time.
+func (t *restartTracker) observe(key, action string, attributes map[string]string, at time.Time) {
+	if t.lifecycles == nil {
+		t.lifecycles = map[string]*lifecycle{}
+		t.counts = map[string]map[string]float64{}
+		t.recent = map[string][]time.Time{}
+		t.oomKills = map[string]float64{}
+	}
+	t.prune(key, at)
+	l := t.lifecycles[key]
+	if l == nil {
+		l = &lifecycle{}
+		t.lifecycles[key] = l
+	}
+	switch action {
+	case "oom":
+		l.oom = true
+		t.oomKills[key]++
+	case "kill", "stop", "restart":
+		l.manual = true
+	case "health_status: unhealthy":
+		l.unhealthy = true
+	case "health_status: healthy":
+		l.unhealthy = false
+	case "die":
+		l.died = true
+		l.exitCode = attributes["exitCode"]
+	case "start":
+		if l.died {
+			if t.counts[key] == nil {
+				t.counts[key] = map[string]float64{}
+			}
+			t.counts[key][l.cause()]++
+			t.recent[key] = append(t.recent[key], at)
+		}
+		t.lifecycles[key] = &lifecycle{}
+	case "destroy":
+		delete(t.lifecycles, key)
+	}
+}
+
+// count returns the number of restarts of the container with the cause.
+func (t *restartTracker) count(key, cause string) float64 {
+	return t.counts[key][cause]
+}
+
+// ooms returns the number of OOM kills of the container.
+func (t *restartTracker) ooms(key string) float64 {
+	return t.oomKills[key]
+}
+
+// recentCount returns the number of restarts of the container within window
+// before now.
+func (t *restartTracker) recentCount(key string, now time.Time) float64 {
+	var n float64
+	for _, at := range t.recent[key] {
+		if now.Sub(at) <= t.window {
+			n++
+		}
+	}
+	return n
+}
+
+// prune forgets the restarts older than window. Only observe calls it, as
+// scrapes only hold a read lock.
+func (t *restartTracker) prune(key string, now time.Time) {
+	recent := t.recent[key]
+	for len(recent) > 0 && now.Sub(recent[0]) > t.window {
+		recent = recent[1:]
+	}
+	if len(recent) == 0 {
+		delete(t.recent, key)
+	} else {
+		t.recent[key] = recent
+	}
+}
+
+// rename moves the counters of a container whose key changed.
+func (t *restartTracker) rename(from, to string) {
+	if counts, ok := t.counts[from]; ok {
+		delete(t.counts, from)
+		t.counts[to] = counts
+	}
+	if recent, ok := t.recent[from]; ok {
+		delete(t.recent, from)
+		t.recent[to] = recent
+	}
+	if n, ok := t.oomKills[from]; ok {
+		delete(t.oomKills, from)
+		t.oomKills[to] = n
+	}
+}
+
+// forget drops the counters of a container.
+func (t *restartTracker) forget(key string) {
+	delete(t.counts, key)
+	delete(t.recent, key)
+	delete(t.oomKills, key)
+}
+
+// restartTotalsRetention is how long the restart total of a container name
+// is kept once no container has it, so a recreation within that time does not
+// reset it.
+const restartTotalsRetention = time.Hour
+
+type restartTotal struct {
+	id        string
+	lastCount int
+	total     float64
+	seen      time.Time
+}
+
+// restartTotals accumulates the restart counts of containers by name, so
+// container_restarts_total keeps increasing when a container is recreated
+// and the restart count of the new one starts again from 0.
+type restartTotals struct {
+	names map[string]restartTotal
+}
+
+// observe records the restart counts of a snapshot taken at now.
+func (t *restartTotals) observe(cache []containerState, now time.Time) {
+	for i := range cache {
+		t.track(&cache[i], now)
+	}
+	t.prune(now)
+}
+
+// track records the restart count of a single container.
+func (t *restartTotals) track(info *containerState, now time.Time) {
+	if t.names == nil {
+		t.names = map[string]restartTotal{}
+	}
+	r, ok := t.names[info.Name]
+	switch {
+	case !ok:
+		r = restartTotal{id: info.ID, lastCount: info.RestartCount, total: float64(info.RestartCount)}
+	case r.id != info.ID || info.RestartCount < r.lastCount:
+		// A new container, its restarts all count.
+		r.id = info.ID
+		r.total += float64(info.RestartCount)
+	default:
+		r.total += float64(info.RestartCount - r.lastCount)
+	}
+	r.lastCount = info.RestartCount
+	r.seen = now
+	t.names[info.Name] = r
+}
+
+// prune forgets the names not seen for restartTotalsRetention.
+func (t *restartTotals) prune(now time.Time) {
+	for name, r := range t.names {
+		if now.Sub(r.seen) > restartTotalsRetention {
+			delete(t.names, name)
+		}
+	}
+}
+
+// total returns the number of restarts of the containers with the name.
+func (t *restartTotals) total(name string) float64 {
+	return t.names[name].total
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	serviceName        = "docker_state_exporter"
+	serviceDescription = "Prometheus exporter for docker container state"
+)
+
+// serviceControl, when set, lets the service manager of the platform stop
+// the exporter by sending to quit.
+var serviceControl func(quit chan<- os.Signal)
+
+// runServiceCommand handles the install-service and uninstall-service
+// subcommands, and tells whether args held one of them. Flags following
+// install-service are validated and passed to the installed service.
+func runServiceCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	var err error
+	switch args[0] {
+	case "install-service":
+		if err := flag.CommandLine.Parse(args[1:]); err != nil {
+			os.Exit(2)
+		}
+		if err := validateFlags(); err != nil {
+			errorLogger.Log("message", fmt.Sprintf("Invalid configuration:\n%v", err))
+			os.Exit(1)
+		}
+		var exe string
+		if exe, err = executablePath(); err == nil {
+			err = installService(exe, serviceArgs())
+		}
+	case "uninstall-service":
+		err = uninstallService()
+	default:
+		return false
+	}
+	if err != nil {
+		errorLogger.Log("message", fmt.Sprintf("Failed to %s: %v", args[0], err))
+		os.Exit(1)
+	}
+	normalLogger.Log("message", fmt.Sprintf("%s done", args[0]), "service", serviceName)
+	return true
+}
+
+// serviceArgs returns the flags set on the command line, for the service to
+// run with the same configuration.
+func serviceArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "check-config" {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return args
+}
+
+func executablePath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
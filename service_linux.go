@@ -0,0 +1,70 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const systemdUnitDir = "/etc/systemd/system"
+
+func systemdUnitPath() string {
+	return filepath.Join(systemdUnitDir, serviceName+".service")
+}
+
+// installService writes a systemd unit running exe with args, then enables
+// and starts it.
+func installService(exe string, args []string) error {
+	words := []string{systemdQuote(exe)}
+	for _, arg := range args {
+		words = append(words, systemdQuote(arg))
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=%s
+Wants=docker.service
+After=network-online.target docker.service
+
+[Service]
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, serviceDescription, strings.Join(words, " "))
+	if err := os.WriteFile(systemdUnitPath(), []byte(unit), 0o644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", "--now", serviceName+".service")
+}
+
+// uninstallService stops and disables the systemd unit, then removes it.
+func uninstallService() error {
+	if err := systemctl("disable", "--now", serviceName+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(systemdUnitPath()); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// systemdQuote quotes s as a single word of an ExecStart line.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s)
+	return `"` + s + `"`
+}
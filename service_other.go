@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func installService(exe string, args []string) error {
+	return fmt.Errorf("services are not supported on %s", runtime.GOOS)
+}
+
+func uninstallService() error {
+	return fmt.Errorf("services are not supported on %s", runtime.GOOS)
+}
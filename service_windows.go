@@ -0,0 +1,87 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func init() {
+	serviceControl = runWindowsService
+}
+
+// installService registers a Windows service running exe with args, then
+// starts it.
+func installService(exe string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Docker State Exporter",
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+// uninstallService stops the Windows service and removes it.
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	// The service may already be stopped.
+	s.Control(svc.Stop)
+	return s.Delete()
+}
+
+// runWindowsService reports to the service control manager when running as
+// a Windows service, and stops the exporter when asked to.
+func runWindowsService(quit chan<- os.Signal) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return
+	}
+	if err := svc.Run(serviceName, &windowsService{quit}); err != nil {
+		errorLogger.Log("message", fmt.Sprintf("Failed to run as a Windows service: %v", err))
+	}
+}
+
+type windowsService struct {
+	quit chan<- os.Signal
+}
+
+func (ws *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			ws.quit <- os.Interrupt
+			return false, 0
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Severity levels of container_severity.
+const (
+	severityOK = iota
+	severityWarning
+	severityCritical
+)
+
+// severityRule maps the containers matching all of its set fields to
+// Severity. Unset fields match any container.
+type severityRule struct {
+	Status   string            `yaml:"status"`
+	Health   string            `yaml:"health"`
+	ExitCode *int              `yaml:"exit_code"`
+	Labels   map[string]string `yaml:"labels"`
+	Severity int               `yaml:"severity"`
+}
+
+func (r *severityRule) validate() error {
+	if r.Severity < severityOK || r.Severity > severityCritical {
+		return fmt.Errorf("severity must be between %d and %d, got %d", severityOK, severityCritical, r.Severity)
+	}
+	if r.Status != "" && !containsString(containerStatuses, r.Status) {
+		return fmt.Errorf("unknown status %q", r.Status)
+	}
+	if r.Health != "" && !containsString(healthStatuses, r.Health) {
+		return fmt.Errorf("unknown health %q", r.Health)
+	}
+	return nil
+}
+
+func (r *severityRule) matches(info *containerState) bool {
+	if r.Status != "" && r.Status != info.Status {
+		return false
+	}
+	if r.Health != "" && r.Health != info.Health {
+		return false
+	}
+	if r.ExitCode != nil && *r.ExitCode != info.ExitCode {
+		return false
+	}
+	for k, v := range r.Labels {
+		if actual, ok := info.Labels[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// containerSeverity returns the severity of the first matching rule, or
+// severityOK if none matches.
+func containerSeverity(rules []severityRule, info *containerState) int {
+	for i := range rules {
+		if rules[i].matches(info) {
+			return rules[i].Severity
+		}
+	}
+	return severityOK
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// shard selects a deterministic subset of the containers, so several
+// exporters on the same host can split the Docker API load and the metrics.
+type shard struct {
+	index, total uint32
+}
+
+func newShard(index, total int) (shard, error) {
+	if total < 1 {
+		return shard{}, fmt.Errorf("shard total must be at least 1, got %d", total)
+	}
+	if index < 0 || index >= total {
+		return shard{}, fmt.Errorf("shard index must be between 0 and %d, got %d", total-1, index)
+	}
+	return shard{uint32(index), uint32(total)}, nil
+}
+
+// parseShard parses a shard given as N/M, the index N from 0 to M-1 of this
+// exporter among M exporters.
+func parseShard(spec string) (shard, error) {
+	n, m, ok := strings.Cut(spec, "/")
+	if !ok {
+		return shard{}, fmt.Errorf("shard %q must be N/M", spec)
+	}
+	index, err := strconv.Atoi(n)
+	if err != nil {
+		return shard{}, fmt.Errorf("shard %q must be N/M: %w", spec, err)
+	}
+	total, err := strconv.Atoi(m)
+	if err != nil {
+		return shard{}, fmt.Errorf("shard %q must be N/M: %w", spec, err)
+	}
+	return newShard(index, total)
+}
+
+// contains reports whether the container with the given ID belongs to s.
+func (s shard) contains(id string) bool {
+	if s.total <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32()%s.total == s.index
+}
+
+func (s shard) filter(containers []types.Container) []types.Container {
+	if s.total <= 1 {
+		return containers
+	}
+	var filtered []types.Container
+	for _, container := range containers {
+		if s.contains(container.ID) {
+			filtered = append(filtered, container)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotInterval is the minimum time between two writes of
+// -collector.snapshot-file, so short cache periods do not write it on every
+// scrape.
+const snapshotInterval = 30 * time.Second
+
+// snapshot is the content of -collector.snapshot-file: the cache as of the
+// last successful refresh.
+type snapshot struct {
+	Time       time.Time        `json:"time"`
+	Containers []containerState `json:"containers"`
+}
+
+func readSnapshot(path string) (snapshot, error) {
+	var s snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// writeSnapshot replaces the file at path, through a temporary file so a
+// crash never leaves a truncated snapshot.
+func writeSnapshot(path string, s snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// restoreSnapshot fills the cache from the snapshot file, so the first
+// scrapes after a restart export the last known state instead of nothing.
+// The data is as stale as the snapshot, see docker_exporter_data_stale_seconds
+// and docker_exporter_snapshot_restored.
+func (c *dockerHealthCollector) restoreSnapshot() {
+	s, err := readSnapshot(c.snapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		warnLogger.Log("message", fmt.Sprintf("Failed to read snapshot %s: %v", c.snapshotFile, err))
+		return
+	}
+	cache := make([]containerState, 0, len(s.Containers))
+	for _, info := range s.Containers {
+		// The shard may have changed since the snapshot was written.
+		if c.shard.contains(info.ID) {
+			cache = append(cache, info)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setCache(cache, s.Time)
+	c.lastSuccess = s.Time
+	c.restored = true
+	normalLogger.Log("message", "Restored container snapshot", "containers", len(cache), "age", time.Since(s.Time).Round(time.Second).String())
+}
+
+// saveSnapshot writes the cache to the snapshot file. The cache is never
+// modified in place, so it can be read without holding c.mu.
+func (c *dockerHealthCollector) saveSnapshot(cache []containerState, now time.Time) {
+	if err := writeSnapshot(c.snapshotFile, snapshot{now, cache}); err != nil {
+		warnLogger.Log("message", fmt.Sprintf("Failed to write snapshot %s: %v", c.snapshotFile, err))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stream collects the containers for a single scrape without caching them,
+// for -collector.streaming. They are inspected -collector.workers at a time
+// and their metrics are emitted right away, so memory stays bounded on hosts
+// with thousands of containers.
+func (c *dockerHealthCollector) stream(ctx context.Context, ch chan<- prometheus.Metric) error {
+	now := time.Now()
+	c.mu.Lock()
+	allowed := c.breaker.allow(now)
+	if !allowed {
+		c.trackOutage(now, true)
+	}
+	c.mu.Unlock()
+
+	err := errCircuitOpen
+	if allowed {
+		c.daemonVisible.Store(findProcess(c.procfs, "dockerd") != 0)
+		err = c.streamContainers(ctx, ch, now)
+		unreachable := errors.Is(err, errDaemonUnreachable)
+		c.mu.Lock()
+		if err == nil {
+			c.lastSuccess = now
+		}
+		c.trackOutage(now, unreachable)
+		c.breaker.record(now, unreachable)
+		c.lastseen = now
+		c.lastDuration = time.Since(now)
+		c.mu.Unlock()
+	}
+	if err != nil {
+		c.lastError.Store(&collectError{err.Error(), now})
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.collectSelfMetrics(ch)
+	if c.stateEnum {
+		collectStatusCodes(ch)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to collect containers: %w", err)
+	}
+	return nil
+}
+
+func (c *dockerHealthCollector) streamContainers(ctx context.Context, ch chan<- prometheus.Metric, now time.Time) error {
+	begin := time.Now()
+	containers, err := c.containerClient.ContainerList(ctx, types.ContainerListOptions{All: true})
+	c.phaseDurations[phaseList].Store(int64(time.Since(begin)))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errDaemonUnreachable, err)
+	}
+	containers = c.shard.filter(containers)
+	c.cacheSize.Store(int64(len(containers)))
+
+	var errs []error
+	var inspectDuration, emitDuration time.Duration
+	seen := make(map[string]bool, len(containers))
+	images := map[string]bool{}
+	var uptime *uptimeHistogram
+	if c.exportUptime {
+		uptime = newUptimeHistogram(now)
+	}
+	for start := 0; start < len(containers); start += c.workers {
+		end := start + c.workers
+		if end > len(containers) {
+			end = len(containers)
+		}
+		batch := containers[start:end]
+
+		begin = time.Now()
+		results := c.inspectAll(ctx, batch)
+		inspectDuration += time.Since(begin)
+
+		begin = time.Now()
+		for i, result := range results {
+			if result.err != nil {
+				if !client.IsErrNotFound(result.err) {
+					errs = append(errs, fmt.Errorf("inspect %s: %w", batch[i].ID, result.err))
+				}
+				continue
+			}
+			info, err := result.state()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("inspect %s: %w", batch[i].ID, err))
+				continue
+			}
+			info.metricLabels = c.metricLabels(&info)
+			seen[info.ID] = true
+			images[info.ImageID] = true
+			c.mu.Lock()
+			c.statuses.track(&info, now)
+			c.warmups.track(&info)
+			c.starts.track(&info)
+			c.restartTotals.track(&info, now)
+			c.mu.Unlock()
+
+			c.mu.RLock()
+			err = errors.Join(c.collectContainerMetrics(ch, info), c.collectRestartsTotal(ch, &info))
+			c.mu.RUnlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("container %s: %w", info.ID, err))
+			}
+			if uptime != nil {
+				uptime.observe(&info)
+			}
+		}
+		emitDuration += time.Since(begin)
+	}
+	if uptime != nil {
+		ch <- uptime.metric()
+	}
+	c.mu.Lock()
+	c.statuses.retain(seen)
+	c.warmups.retain(seen)
+	c.starts.retain(seen)
+	c.images.retain(images)
+	c.restartTotals.prune(now)
+	c.mu.Unlock()
+
+	c.phaseDurations[phaseInspect].Store(int64(inspectDuration))
+	c.phaseDurations[phaseEmit].Store(int64(emitDuration))
+	return errors.Join(errs...)
+}
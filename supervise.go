@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watchdogTolerance is how many intervals a supervised loop may go without a
+// heartbeat before it is considered stalled.
+const watchdogTolerance = 3
+
+// A loop that keeps dying before its first heartbeat, such as the event loop
+// while the daemon is down, is restarted after a delay doubling from
+// minRestartDelay up to maxRestartDelay.
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// The reasons of loop restarts.
+const (
+	restartExited   = "exited"
+	restartPanicked = "panicked"
+	restartStalled  = "stalled"
+)
+
+var loopRestartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "docker_exporter_loop_restarts_total",
+	Help: "Number of times a background loop was restarted, by reason: exited, panicked or stalled.",
+}, []string{"loop", "reason"})
+
+// supervise runs loop in the background and restarts it whenever it returns,
+// panics, or goes more than watchdogTolerance intervals without calling
+// heartbeat. The context given to loop is canceled when it gets replaced.
+func supervise(name string, interval time.Duration, loop func(ctx context.Context, heartbeat func())) {
+	go func() {
+		delay := minRestartDelay
+		for {
+			reason, detail, progressed := superviseOnce(name, interval, loop)
+			if progressed {
+				delay = minRestartDelay
+			}
+			loopRestartsTotal.WithLabelValues(name, reason).Inc()
+			errorLogger.Log("message", fmt.Sprintf("Restarting the %s loop in %v, it %s", name, delay, detail))
+			time.Sleep(delay)
+			if !progressed {
+				delay *= 2
+				if delay > maxRestartDelay {
+					delay = maxRestartDelay
+				}
+			}
+		}
+	}()
+}
+
+// superviseOnce runs loop until it dies or stalls, and tells why, with a
+// description for the logs, and whether it called heartbeat at all.
+func superviseOnce(name string, interval time.Duration, loop func(ctx context.Context, heartbeat func())) (reason, detail string, progressed bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	beats := make(chan struct{}, 1)
+	heartbeat := func() {
+		select {
+		case beats <- struct{}{}:
+		default:
+		}
+	}
+	done := make(chan struct{})
+	panicked := true
+	go func() {
+		defer close(done)
+		defer recoverPanic(name + " loop")
+		loop(ctx, heartbeat)
+		panicked = false
+	}()
+
+	timeout := watchdogTolerance * interval
+	watchdog := time.NewTimer(timeout)
+	defer watchdog.Stop()
+	for {
+		select {
+		case <-beats:
+			progressed = true
+			if !watchdog.Stop() {
+				<-watchdog.C
+			}
+			watchdog.Reset(timeout)
+		case <-done:
+			// The loop may have sent a last heartbeat before returning.
+			select {
+			case <-beats:
+				progressed = true
+			default:
+			}
+			if panicked {
+				return restartPanicked, "panicked", progressed
+			}
+			return restartExited, "exited", progressed
+		case <-watchdog.C:
+			// A deadlocked loop may never return, it is abandoned.
+			return restartStalled, fmt.Sprintf("made no progress for %v", timeout), progressed
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSuperviseOnce(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		loop           func(ctx context.Context, heartbeat func())
+		wantReason     string
+		wantProgressed bool
+	}{
+		{"exited", func(ctx context.Context, heartbeat func()) {}, restartExited, false},
+		{"exited after progress", func(ctx context.Context, heartbeat func()) { heartbeat() }, restartExited, true},
+		{"panicked", func(ctx context.Context, heartbeat func()) { panic("boom") }, restartPanicked, false},
+		{"stalled", func(ctx context.Context, heartbeat func()) { <-ctx.Done() }, restartStalled, false},
+	} {
+		reason, _, progressed := superviseOnce("test", 10*time.Millisecond, tt.loop)
+		if reason != tt.wantReason || progressed != tt.wantProgressed {
+			t.Errorf("%s: superviseOnce() = %q, %v, want %q, %v", tt.name, reason, progressed, tt.wantReason, tt.wantProgressed)
+		}
+	}
+}
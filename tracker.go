@@ -0,0 +1,203 @@
+package main
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+type statusSince struct {
+	status string
+	since  time.Time
+}
+
+// statusTracker remembers since when every container has had its current
+// status, from successive snapshots. A status change counts from the docker
+// timestamp matching the status when there is one, see statusStart, and from
+// the time it was first seen otherwise.
+type statusTracker struct {
+	containers map[string]statusSince
+}
+
+// observe records the statuses of a snapshot taken at now. Containers that
+// are no longer present are forgotten.
+func (t *statusTracker) observe(cache []containerState, now time.Time) {
+	previous := t.containers
+	t.containers = make(map[string]statusSince, len(cache))
+	for _, info := range cache {
+		s, ok := previous[info.ID]
+		if !ok || s.status != info.Status {
+			s = statusSince{info.Status, statusStart(&info, now)}
+		}
+		t.containers[info.ID] = s
+	}
+}
+
+// statusStart returns when the container entered its current status, from
+// the creation, start or finish time, or now for statuses docker does not
+// timestamp, such as restarting or paused.
+func statusStart(info *containerState, now time.Time) time.Time {
+	var timestamp string
+	switch info.Status {
+	case "created":
+		timestamp = info.Created
+	case "running":
+		timestamp = info.StartedAt
+	case "exited":
+		timestamp = info.FinishedAt
+	}
+	if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil && !t.IsZero() && t.Before(now) {
+		return t
+	}
+	return now
+}
+
+// duration returns how long the container has had its current status.
+func (t *statusTracker) duration(id string, now time.Time) time.Duration {
+	s, ok := t.containers[id]
+	if !ok {
+		return 0
+	}
+	return now.Sub(s.since)
+}
+
+// track records the status of a single container at now, for streaming
+// collections which never hold a whole snapshot.
+func (t *statusTracker) track(info *containerState, now time.Time) {
+	if t.containers == nil {
+		t.containers = map[string]statusSince{}
+	}
+	if s, ok := t.containers[info.ID]; !ok || s.status != info.Status {
+		t.containers[info.ID] = statusSince{info.Status, statusStart(info, now)}
+	}
+}
+
+// retain forgets the containers missing from ids.
+func (t *statusTracker) retain(ids map[string]bool) {
+	for id := range t.containers {
+		if !ids[id] {
+			delete(t.containers, id)
+		}
+	}
+}
+
+type warmup struct {
+	startedAt string
+	// observed is whether the exporter saw the container start, or at least
+	// still starting, so its first healthy status after the start is known.
+	observed bool
+	done     bool
+	seconds  float64
+}
+
+// warmupTracker remembers how long every container took to become healthy
+// after its last start. Containers already healthy in the first snapshot are
+// left out, as their first healthy status may be long gone from the
+// healthcheck log.
+type warmupTracker struct {
+	initialized bool
+	containers  map[string]warmup
+}
+
+// observe records the health statuses of a snapshot. Containers that are no
+// longer present are forgotten.
+func (t *warmupTracker) observe(cache []containerState) {
+	ids := make(map[string]bool, len(cache))
+	for i := range cache {
+		t.track(&cache[i])
+		ids[cache[i].ID] = true
+	}
+	t.retain(ids)
+}
+
+// track records the health status of a single container.
+func (t *warmupTracker) track(info *containerState) {
+	if t.containers == nil {
+		t.containers = map[string]warmup{}
+	}
+	w, ok := t.containers[info.ID]
+	if !ok || w.startedAt != info.StartedAt {
+		w = warmup{startedAt: info.StartedAt, observed: ok || t.initialized}
+	}
+	if info.Health == types.Starting {
+		w.observed = true
+	}
+	if w.observed && !w.done && info.Health == types.Healthy {
+		if started, err := time.Parse(time.RFC3339Nano, info.StartedAt); err == nil && info.FirstHealthyAt.After(started) {
+			w.seconds = info.FirstHealthyAt.Sub(started).Seconds()
+			w.done = true
+		}
+	}
+	t.containers[info.ID] = w
+}
+
+// retain forgets the containers missing from ids. It ends the first
+// snapshot.
+func (t *warmupTracker) retain(ids map[string]bool) {
+	for id := range t.containers {
+		if !ids[id] {
+			delete(t.containers, id)
+		}
+	}
+	t.initialized = true
+}
+
+// timeToHealthy returns how long the container took to become healthy after
+// its last start, if known.
+func (t *warmupTracker) timeToHealthy(id string) (float64, bool) {
+	w := t.containers[id]
+	return w.seconds, w.done
+}
+
+// startTracker remembers how long every container took from its creation to
+// its first start. Containers first seen after a restart by their restart
+// policy are left out, as their first start time is lost.
+type startTracker struct {
+	latencies map[string]float64
+}
+
+// observe records the start times of a snapshot. Containers that are no
+// longer present are forgotten.
+func (t *startTracker) observe(cache []containerState) {
+	ids := make(map[string]bool, len(cache))
+	for i := range cache {
+		t.track(&cache[i])
+		ids[cache[i].ID] = true
+	}
+	t.retain(ids)
+}
+
+// track records the start time of a single container.
+func (t *startTracker) track(info *containerState) {
+	if t.latencies == nil {
+		t.latencies = map[string]float64{}
+	}
+	if _, ok := t.latencies[info.ID]; ok || info.RestartCount > 0 {
+		return
+	}
+	created, err := time.Parse(time.RFC3339Nano, info.Created)
+	if err != nil {
+		return
+	}
+	started, err := time.Parse(time.RFC3339Nano, info.StartedAt)
+	if err != nil || !started.After(created) {
+		return
+	}
+	t.latencies[info.ID] = started.Sub(created).Seconds()
+}
+
+// retain forgets the containers missing from ids.
+func (t *startTracker) retain(ids map[string]bool) {
+	for id := range t.latencies {
+		if !ids[id] {
+			delete(t.latencies, id)
+		}
+	}
+}
+
+// latency returns how long the container took from its creation to its first
+// start, if known.
+func (t *startTracker) latency(id string) (float64, bool) {
+	seconds, ok := t.latencies[id]
+	return seconds, ok
+}
@@ -0,0 +1,64 @@
+package main
+
+import "strings"
+
+// healthTransition is a change of the health status of a container.
+type healthTransition struct {
+	from, to string
+}
+
+// healthTracker counts the health status changes of every container from
+// the health_status events. Starts reset the health status to starting. The
+// first health status of a container seen by the exporter is not counted, as
+// the previous one is unknown. Containers are identified by their counter
+// key, see -collector.counters-key.
+type healthTracker struct {
+	statuses map[string]string
+	counts   map[string]map[healthTransition]float64
+}
+
+// observe records a container event.
+func (t *healthTracker) observe(key, action string) {
+	if t.statuses == nil {
+		t.statuses = map[string]string{}
+		t.counts = map[string]map[healthTransition]float64{}
+	}
+	switch {
+	case action == "start":
+		t.statuses[key] = "starting"
+	case action == "destroy":
+		delete(t.statuses, key)
+	case strings.HasPrefix(action, "health_status: "):
+		status := strings.TrimPrefix(action, "health_status: ")
+		if from, ok := t.statuses[key]; ok && from != status {
+			if t.counts[key] == nil {
+				t.counts[key] = map[healthTransition]float64{}
+			}
+			t.counts[key][healthTransition{from, status}]++
+		}
+		t.statuses[key] = status
+	}
+}
+
+// transitions returns the number of health status changes of the container,
+// by transition.
+func (t *healthTracker) transitions(key string) map[healthTransition]float64 {
+	return t.counts[key]
+}
+
+// rename moves the counters of a container whose key changed.
+func (t *healthTracker) rename(from, to string) {
+	if status, ok := t.statuses[from]; ok {
+		delete(t.statuses, from)
+		t.statuses[to] = status
+	}
+	if counts, ok := t.counts[from]; ok {
+		delete(t.counts, from)
+		t.counts[to] = counts
+	}
+}
+
+// forget drops the counters of a container.
+func (t *healthTracker) forget(key string) {
+	delete(t.counts, key)
+}
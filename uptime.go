@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// uptimeBuckets are the upper bounds of container_uptime_seconds, from a
+// minute to a month.
+var uptimeBuckets = []float64{60, 300, 900, 3600, 6 * 3600, 24 * 3600, 7 * 24 * 3600, 30 * 24 * 3600}
+
+var uptimeHistogramDesc = newDescSource(
+	"container_uptime_seconds",
+	"Distribution of the time since the running containers started.")
+
+// uptimeHistogram aggregates the uptime of the running containers into a
+// single histogram, so fleet-wide churn shows without a series per container.
+type uptimeHistogram struct {
+	now     time.Time
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func newUptimeHistogram(now time.Time) *uptimeHistogram {
+	h := &uptimeHistogram{now: now, buckets: make(map[float64]uint64, len(uptimeBuckets))}
+	for _, bound := range uptimeBuckets {
+		h.buckets[bound] = 0
+	}
+	return h
+}
+
+// uptimeSeconds returns the time since a running container started. It is
+// false for other containers and for containers without a start time, such as
+// with -collector.fast.
+func uptimeSeconds(info *containerState, now time.Time) (float64, bool) {
+	if info.Status != "running" {
+		return 0, false
+	}
+	startedAt, err := parseTimestamp(info.StartedAt)
+	if err != nil || startedAt == 0 {
+		return 0, false
+	}
+	if uptime := float64(now.Unix()) - startedAt; uptime > 0 {
+		return uptime, true
+	}
+	return 0, true
+}
+
+// observe adds the container if it is running.
+func (h *uptimeHistogram) observe(info *containerState) {
+	uptime, ok := uptimeSeconds(info, h.now)
+	if !ok {
+		return
+	}
+	h.count++
+	h.sum += uptime
+	for _, bound := range uptimeBuckets {
+		if uptime <= bound {
+			h.buckets[bound]++
+		}
+	}
+}
+
+func (h *uptimeHistogram) metric() prometheus.Metric {
+	return prometheus.MustNewConstHistogram(uptimeHistogramDesc.Desc(nil), h.count, h.sum, h.buckets)
+}
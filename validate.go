@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// validateFlags checks the flags and the config file, so that problems are
+// reported together at startup rather than at first use.
+func validateFlags() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(*address); err != nil {
+		check(fmt.Errorf("-listen-address: %w", err))
+	}
+	if *configFile != "" {
+		if cfg, err := loadConfig(*configFile); err != nil {
+			check(fmt.Errorf("-config.file: %w", err))
+		} else if cfg.sensitiveEnvSet && len(cfg.SensitiveEnvPatterns) > 0 {
+			// The environment is unknown, container_env_sensitive_vars
+			// would not be exported.
+			if *fast {
+				check(errors.New("-config.file: sensitive_env_patterns cannot be used with -collector.fast"))
+			}
+			if fields, err := parseInspectFields(*fieldsFlag); err == nil && !fields.has("config") {
+				check(errors.New("-config.file: sensitive_env_patterns requires the config section of -inspect.fields"))
+			}
+		}
+	}
+	if info, err := os.Stat(*procfs); err == nil && !info.IsDir() {
+		check(fmt.Errorf("-path.procfs: %s is not a directory", *procfs))
+	}
+	if info, err := os.Stat(*rootfs); err == nil && !info.IsDir() {
+		check(fmt.Errorf("-path.rootfs: %s is not a directory", *rootfs))
+	}
+	if *snapshotFile != "" {
+		if info, err := os.Stat(filepath.Dir(*snapshotFile)); err != nil {
+			check(fmt.Errorf("-collector.snapshot-file: %w", err))
+		} else if !info.IsDir() {
+			check(fmt.Errorf("-collector.snapshot-file: %s is not a directory", filepath.Dir(*snapshotFile)))
+		}
+	}
+
+	for _, f := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"collector.state.timeout", *stateTimeout},
+		{"collector.daemon.timeout", *daemonTimeout},
+		{"collector.info.timeout", *infoTimeout},
+		{"docker.circuit-breaker.probe-interval", *breakerProbe},
+		{"collector.events.resync-interval", *resyncInterval},
+		{"collector.restarts-window", *restartsWindow},
+	} {
+		if f.d <= 0 {
+			check(fmt.Errorf("-%s must be positive, got %v", f.name, f.d))
+		}
+	}
+	for _, f := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"web.timeout-offset", *timeoutOffset},
+		{"collector.cache-duration", *cacheDuration},
+		{"collector.removed-ttl", *removedTTL},
+		{"docker.outage-grace-period", *outageGrace},
+		{"collector.poll-interval", *pollInterval},
+	} {
+		if f.d < 0 {
+			check(fmt.Errorf("-%s must not be negative, got %v", f.name, f.d))
+		}
+	}
+	for _, f := range []struct {
+		name string
+		n    int
+	}{
+		{"web.max-requests", *maxRequests},
+		{"docker.inspect-retries", *retries},
+		{"docker.circuit-breaker.threshold", *breakerThreshold},
+	} {
+		if f.n < 0 {
+			check(fmt.Errorf("-%s must not be negative, got %d", f.name, f.n))
+		}
+	}
+
+	if *countersKey != "id" && *countersKey != "name" {
+		check(fmt.Errorf("-collector.counters-key must be id or name, got %q", *countersKey))
+	}
+	if !containsString(stateEncodings, *stateEncoding) {
+		check(fmt.Errorf("-metrics.state-encoding must be labels or enum, got %q", *stateEncoding))
+	}
+	if *maxAPIRate < 0 {
+		check(fmt.Errorf("-docker.max-requests-per-second must not be negative, got %v", *maxAPIRate))
+	}
+	if *workers < 1 {
+		check(fmt.Errorf("-collector.workers must be at least 1, got %d", *workers))
+	}
+	if *streaming {
+		// Streaming collections keep no cache.
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"collector.poll-interval", *pollInterval > 0},
+			{"collector.events", *watchEvents},
+			{"collector.incremental", *incremental},
+			{"collector.fast", *fast},
+			{"collector.removed-ttl", *removedTTL > 0},
+			{"collector.snapshot-file", *snapshotFile != ""},
+			{"collector.compress-cache", *compressCacheFlag},
+		} {
+			if f.set {
+				check(fmt.Errorf("-collector.streaming cannot be combined with -%s", f.name))
+			}
+		}
+	}
+	if *fast {
+		// Both inspect containers, whose full state would then be mixed
+		// with the states built from the list.
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"collector.events", *watchEvents},
+			{"collector.incremental", *incremental},
+		} {
+			if f.set {
+				check(fmt.Errorf("-collector.fast cannot be combined with -%s", f.name))
+			}
+		}
+	}
+	if *etag && *pollInterval == 0 {
+		check(errors.New("-web.etag requires -collector.poll-interval"))
+	}
+	if *shardSpec != "" {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["shard.index"] || set["shard.total"] {
+			check(errors.New("-collector.shard cannot be combined with -shard.index and -shard.total"))
+		}
+		if _, err := parseShard(*shardSpec); err != nil {
+			check(fmt.Errorf("-collector.shard: %w", err))
+		}
+	} else if _, err := newShard(*shardIndex, *shardTotal); err != nil {
+		check(fmt.Errorf("-shard.index/-shard.total: %w", err))
+	}
+	if _, err := parseInspectFields(*fieldsFlag); err != nil {
+		check(fmt.Errorf("-inspect.fields: %w", err))
+	}
+	return errors.Join(errs...)
+}
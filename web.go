@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeCollector binds the exporter to the context of a single scrape.
+type scrapeCollector struct {
+	ctx context.Context
+	e   *exporter
+}
+
+func (s scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.e.Describe(ch)
+}
+
+func (s scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.e.collect(s.ctx, ch)
+}
+
+// scrapeContext returns the context of a scrape. When Prometheus announces its
+// scrape timeout, the context expires offset before it, so partial data is
+// returned instead of the scrape timing out.
+func scrapeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc) {
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err == nil && seconds > 0 {
+			timeout := time.Duration(seconds*float64(time.Second)) - offset
+			if timeout <= 0 {
+				timeout = time.Duration(seconds * float64(time.Second))
+			}
+			return context.WithTimeout(r.Context(), timeout)
+		}
+	}
+	return context.WithCancel(r.Context())
+}
+
+type freshKey struct{}
+
+// withFresh returns a context asking collectors to bypass their cache.
+func withFresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey{}, true)
+}
+
+// freshRequested reports whether the scrape asked for fresh data, with
+// ?cached=false or ?refresh=1.
+func freshRequested(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshKey{}).(bool)
+	return fresh
+}
+
+// metricsHandler serves the metrics of the default registry and e, collected
+// within the scrape deadline. At most maxRequests scrapes are served
+// concurrently, unless it is 0. When generation is not nil, responses are
+// reused while it is unchanged and tagged with an ETag.
+func metricsHandler(e *exporter, offset time.Duration, maxRequests int, generation func() int64, opts promhttp.HandlerOpts) http.Handler {
+	var inFlight chan struct{}
+	if maxRequests > 0 {
+		inFlight = make(chan struct{}, maxRequests)
+	}
+	var cache etagCache
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inFlight != nil {
+			select {
+			case inFlight <- struct{}{}:
+				defer func() { <-inFlight }()
+			default:
+				http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", maxRequests), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		ctx, cancel := scrapeContext(r, offset)
+		defer cancel()
+		q := r.URL.Query()
+		fresh := q.Get("cached") == "false" || q.Get("refresh") == "1"
+		if fresh {
+			ctx = withFresh(ctx)
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scrapeCollector{ctx, e})
+		gatherers := prometheus.Gatherers{registry, prometheus.DefaultGatherer}
+		handler := promhttp.HandlerFor(gatherers, opts)
+		if generation == nil || fresh {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		cache.serve(w, r, generation(), handler)
+	})
+}
+
+// etagCache keeps the last response of the metrics handler, so unchanged
+// metrics are not collected and sent again.
+type etagCache struct {
+	mu         sync.Mutex
+	generation int64
+	etag       string
+	header     http.Header
+	body       []byte
+}
+
+// serve answers r from the cached response when it was rendered for the same
+// generation and request headers, and with 304 Not Modified when the
+// scraper already has it.
+func (ec *etagCache) serve(w http.ResponseWriter, r *http.Request, generation int64, handler http.Handler) {
+	// The representation depends on the negotiated format and encoding.
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\x00%s\x00%s", generation, r.Header.Get("Accept"), r.Header.Get("Accept-Encoding"))
+	etag := fmt.Sprintf(`"%x"`, h.Sum64())
+
+	ec.mu.Lock()
+	cached, header, body := ec.etag == etag, ec.header, ec.body
+	ec.mu.Unlock()
+	if !cached {
+		// Rendering can take a while on large hosts, concurrent scrapes
+		// render on their own rather than wait for each other.
+		rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK {
+			copyHeader(w.Header(), rec.header)
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+		header, body = rec.header, rec.body.Bytes()
+		ec.mu.Lock()
+		// Keep the newest response when a scrape of an older generation
+		// finishes last.
+		if generation >= ec.generation {
+			ec.generation, ec.etag, ec.header, ec.body = generation, etag, header, body
+		}
+		ec.mu.Unlock()
+	}
+
+	copyHeader(w.Header(), header)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
+
+// responseRecorder buffers a response.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) Header() http.Header         { return rr.header }
+func (rr *responseRecorder) WriteHeader(status int)      { rr.status = status }
+func (rr *responseRecorder) Write(b []byte) (int, error) { return rr.body.Write(b) }
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}